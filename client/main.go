@@ -79,6 +79,7 @@ func main() {
 
 	cmd := exec.Command("docker", os.Args[1:]...)
 	cmd.Env = []string{fmt.Sprintf("DOCKER_HOST=tcp://%s", l.Addr())}
+	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	fmt.Println("Running...", cmd.String())
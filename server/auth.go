@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+
+	"github.com/docker/docker/errdefs"
 )
 
 type AuthMiddleware struct {
@@ -39,9 +41,36 @@ type namespaceKey struct{}
 type podKey struct{}
 
 func GetNamespace(ctx context.Context) string {
-	return ctx.Value(namespaceKey{}).(string)
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
 }
 
 func GetPod(ctx context.Context) string {
-	return ctx.Value(podKey{}).(string)
+	pod, _ := ctx.Value(podKey{}).(string)
+	return pod
+}
+
+// checkAccess verifies that the given namespace and pod belong to the caller.
+// Other pods are reported as not found so callers can't probe for them.
+func checkAccess(ctx context.Context, ns, pod, name string) error {
+	callerNS, callerPod, err := getPod(ctx)
+	if err != nil {
+		return errdefs.Unauthorized(err)
+	}
+	if ns != callerNS || pod != callerPod {
+		return errdefs.NotFound(fmt.Errorf("No such container: %s", name))
+	}
+	return nil
+}
+
+// checkExecAccess verifies that an exec belongs to the caller.
+func (b *Backend) checkExecAccess(ctx context.Context, id string) error {
+	e, err := b.execs.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := checkAccess(ctx, e.container.Namespace, e.container.Pod, id); err != nil {
+		return errdefs.NotFound(fmt.Errorf("no such exec: %s", id))
+	}
+	return nil
 }
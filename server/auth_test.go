@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func callerContext(ns, pod string) context.Context {
+	ctx := context.WithValue(context.Background(), namespaceKey{}, ns)
+	return context.WithValue(ctx, podKey{}, pod)
+}
+
+// testPod returns a pod with the given ephemeral containers, tracked by the
+// informer.
+func testPod(ns, name string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+			Labels:    map[string]string{trackedLabel: "true"},
+		},
+	}
+	for _, c := range containers {
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name:  c,
+				Image: "cgr.dev/chainguard/bash",
+			},
+		})
+	}
+	return pod
+}
+
+func newTestBackend(t *testing.T, objs ...runtime.Object) *Backend {
+	t.Helper()
+
+	client := fake.NewSimpleClientset(objs...)
+	pods := newPodWatcher(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := pods.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return &Backend{
+		client:    client,
+		pods:      pods,
+		podLocks:  newPodLocks(),
+		execs:     NewExecStore(NewMemoryExecPersister(), time.Hour, 10),
+		events:    newEventBus(pods),
+		terminals: newTerminals(),
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	ctx := callerContext("ns", "pod")
+	for _, tc := range []struct {
+		ns, pod string
+		wantErr bool
+	}{
+		{ns: "ns", pod: "pod"},
+		{ns: "ns", pod: "otherpod", wantErr: true},
+		{ns: "otherns", pod: "pod", wantErr: true},
+		{ns: "otherns", pod: "otherpod", wantErr: true},
+	} {
+		err := checkAccess(ctx, tc.ns, tc.pod, "levias-abc")
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("checkAccess(%s, %s) = %v, wantErr %t", tc.ns, tc.pod, err, tc.wantErr)
+		}
+		if err != nil && !errdefs.IsNotFound(err) {
+			t.Errorf("checkAccess(%s, %s) = %v, want not found error", tc.ns, tc.pod, err)
+		}
+	}
+}
+
+func TestCheckAccessNoClaims(t *testing.T) {
+	err := checkAccess(context.Background(), "ns", "pod", "levias-abc")
+	if !errdefs.IsUnauthorized(err) {
+		t.Errorf("checkAccess() = %v, want unauthorized error", err)
+	}
+}
+
+func TestNameTransform(t *testing.T) {
+	own := containerRef{Namespace: "ns", Pod: "pod", Name: "levias-abc"}
+	other := containerRef{Namespace: "otherns", Pod: "otherpod", Name: "levias-xyz"}
+	b := newTestBackend(t,
+		testPod(own.Namespace, own.Pod, own.Name),
+		testPod(other.Namespace, other.Pod, other.Name),
+	)
+
+	ctx := context.Background()
+	ownExec := "ownexec"
+	if _, err := b.execs.Add(ctx, ownExec, own, &types.ExecConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	otherExec := "otherexec"
+	if _, err := b.execs.Add(ctx, otherExec, other, &types.ExecConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc     string
+		path     string
+		vars     map[string]string
+		wantVars map[string]string
+		wantErr  bool
+	}{
+		{
+			desc:     "name",
+			path:     "/v1.45/containers/levias-abc/logs",
+			vars:     map[string]string{"name": "levias-abc"},
+			wantVars: map[string]string{"name": own.String()},
+		},
+		{
+			desc:     "full ID",
+			path:     "/v1.45/containers/" + own.ID() + "/attach",
+			vars:     map[string]string{"name": own.ID()},
+			wantVars: map[string]string{"name": own.String()},
+		},
+		{
+			desc:     "short ID",
+			path:     "/v1.45/containers/" + own.ID()[:12] + "/wait",
+			vars:     map[string]string{"name": own.ID()[:12]},
+			wantVars: map[string]string{"name": own.String()},
+		},
+		{
+			desc:     "other pod ID stays in caller's pod",
+			path:     "/v1.45/containers/" + other.ID() + "/attach",
+			vars:     map[string]string{"name": other.ID()},
+			wantVars: map[string]string{"name": "ns/pod/" + other.ID()},
+		},
+		{
+			desc:     "other pod name stays in caller's pod",
+			path:     "/v1.45/containers/levias-xyz/logs",
+			vars:     map[string]string{"name": other.Name},
+			wantVars: map[string]string{"name": "ns/pod/levias-xyz"},
+		},
+		{
+			desc:     "other pod dotted name stays in caller's pod",
+			path:     "/v1.45/containers/otherns.otherpod.levias-xyz/logs",
+			vars:     map[string]string{"name": "otherns.otherpod.levias-xyz"},
+			wantVars: map[string]string{"name": "ns/pod/otherns.otherpod.levias-xyz"},
+		},
+		{
+			desc:     "own exec start",
+			path:     "/v1.45/exec/" + ownExec + "/start",
+			vars:     map[string]string{"name": ownExec},
+			wantVars: map[string]string{"name": ownExec},
+		},
+		{
+			desc:    "other pod exec start",
+			path:    "/v1.45/exec/" + otherExec + "/start",
+			vars:    map[string]string{"name": otherExec},
+			wantErr: true,
+		},
+		{
+			desc:    "other pod exec inspect",
+			path:    "/v1.45/exec/" + otherExec + "/json",
+			vars:    map[string]string{"id": otherExec},
+			wantErr: true,
+		},
+		{
+			desc:    "unknown exec",
+			path:    "/v1.45/exec/unknown/json",
+			vars:    map[string]string{"id": "unknown"},
+			wantErr: true,
+		},
+		{
+			desc:     "image names are left alone",
+			path:     "/v1.45/images/cgr.dev/chainguard/bash/json",
+			vars:     map[string]string{"name": "cgr.dev/chainguard/bash"},
+			wantVars: map[string]string{"name": "cgr.dev/chainguard/bash"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			called := false
+			handler := (&nameTransform{b: b}).WrapHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+				called = true
+				return nil
+			})
+
+			r := httptest.NewRequest(http.MethodPost, tc.path, nil)
+			err := handler(callerContext(own.Namespace, own.Pod), httptest.NewRecorder(), r, tc.vars)
+			if tc.wantErr {
+				if !errdefs.IsNotFound(err) {
+					t.Fatalf("handler() = %v, want not found error", err)
+				}
+				if called {
+					t.Fatal("handler was called for another pod's exec")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handler() = %v", err)
+			}
+			if !called {
+				t.Fatal("handler was not called")
+			}
+			for k, want := range tc.wantVars {
+				if got := tc.vars[k]; got != want {
+					t.Errorf("vars[%q] = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
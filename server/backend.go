@@ -4,13 +4,10 @@ import (
 	"context"
 	"errors"
 	"runtime"
-	"sync"
 	"time"
 
 	"github.com/docker/docker/api/server/router/system"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/events"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/api/types/swarm"
 	systypes "github.com/docker/docker/api/types/system"
@@ -27,10 +24,23 @@ type Backend struct {
 	system.Backend
 	system.ClusterBackend
 
-	mu       *sync.RWMutex
-	config   *rest.Config
-	client   *kubernetes.Clientset
-	verifier *Verifier
+	config    *rest.Config
+	client    kubernetes.Interface
+	verifier  *Verifier
+	terminals *terminals
+	execs     *ExecStore
+	pods      *podWatcher
+	podLocks  *podLocks
+	events    *eventBus
+
+	// startTimeout bounds how long to wait for a container to start.
+	startTimeout time.Duration
+	// maxInstances bounds how many ephemeral containers a container can
+	// take up in its pod by being started again. Zero means no limit.
+	maxInstances int
+	// strict rejects create options that can't be honored instead of
+	// ignoring them.
+	strict bool
 }
 
 func (b *Backend) SystemInfo(context.Context) (*systypes.Info, error) {
@@ -52,14 +62,6 @@ func (b *Backend) SystemDiskUsage(ctx context.Context, opts system.DiskUsageOpti
 	return nil, ErrUnimplemented
 }
 
-func (b *Backend) SubscribeToEvents(since, until time.Time, ef filters.Args) ([]events.Message, chan interface{}) {
-	return nil, nil
-}
-
-func (b *Backend) UnsubscribeFromEvents(chan interface{}) {
-
-}
-
 func (b *Backend) AuthenticateToRegistry(ctx context.Context, authConfig *registry.AuthConfig) (string, string, error) {
 	return "", "", ErrUnimplemented
 }
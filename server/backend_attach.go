@@ -3,102 +3,260 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strings"
-	"time"
 
 	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/errdefs"
 	"github.com/moby/moby/pkg/stdcopy"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 func (b *Backend) ContainerAttach(name string, c *backend.ContainerAttachConfig) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
 	// y u no pass in context docker?
 	ctx := context.TODO()
 
 	json.NewEncoder(os.Stderr).Encode(c)
 
-	s := strings.Split(name, ".")
-	if len(s) != 3 {
-		return fmt.Errorf("invalid container name %q", name)
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
 	}
-	ns, pod, container := s[0], s[1], s[2]
+	ns, pod, container := ref.Namespace, ref.Pod, ref.Name
 
-	if _, err := b.waitForReady(ctx, ns, pod, container); err != nil {
+	p, ec, err := b.getEphemeralContainer(ctx, ns, pod, container)
+	if err != nil {
 		return err
 	}
+	if isPaused(p, ec) {
+		return errdefs.Conflict(fmt.Errorf("container %s is paused, unpause the container before attach", ref.ID()))
+	}
 
-	req := b.client.CoreV1().Pods(ns).GetLogs(pod, &corev1.PodLogOptions{
-		Container: container,
-	})
-	podLogs, err := req.Stream(ctx)
+	status, err := b.waitForReady(ctx, ns, pod, ec.Instance)
 	if err != nil {
 		return err
 	}
-	defer podLogs.Close()
 
-	// k8s doesn't support separate stdout/stderr :(
-	stdin, stdout, stderr, err := c.GetStreams(c.MuxStreams)
+	// Interactive containers get a real attach session. Everything else follows
+	// the logs instead, since an attach would lose any output written between
+	// the container starting and the client attaching.
+	interactive := status.State.Running != nil && (ec.Stdin || ec.TTY)
+
+	// The client sends its first resize as soon as the connection is
+	// upgraded, so the session's size queue has to be open by then.
+	var sizes *sizeQueue
+	if interactive && ec.TTY {
+		sizes = b.terminals.open(name, containerMetadata(p, ec.Name).ConsoleSize)
+		defer b.terminals.close(name, sizes)
+	}
+
+	// TTY output is never multiplexed, matching the docker daemon.
+	multiplexed := c.MuxStreams && !ec.TTY
+	stdin, stdout, stderr, err := c.GetStreams(multiplexed)
 	if err != nil {
 		return err
 	}
 	defer stdin.Close()
-	if c.MuxStreams {
+	if multiplexed {
 		stderr = stdcopy.NewStdWriter(stderr, stdcopy.Stderr)
 		stdout = stdcopy.NewStdWriter(stdout, stdcopy.Stdout)
 	}
 
-	/*
-		if c.UseStdin {
-			go func() {
-				reader := bufio.NewReader(stdin)
-				for {
-					line, err := reader.ReadBytes('\n')
-					if err != nil {
-						return
-					}
-					fmt.Println(string(line))
-				}
-			}()
+	if interactive {
+		opts := remotecommand.StreamOptions{
+			Tty: ec.TTY,
+		}
+		if sizes != nil {
+			opts.TerminalSizeQueue = sizes
+		}
+		if c.UseStdin && ec.Stdin {
+			opts.Stdin = stdin
+		}
+		if c.UseStdout {
+			opts.Stdout = stdout
+		}
+		// k8s merges stderr into stdout for TTY sessions.
+		if c.UseStderr && !ec.TTY {
+			opts.Stderr = stderr
 		}
-	*/
+		if err := b.attach(ctx, ns, pod, ec.Instance, opts); err != nil {
+			fmt.Fprintf(stderr, "error attaching to container: %v\n", err)
+			return err
+		}
+		return nil
+	}
 
+	req := b.client.CoreV1().Pods(ns).GetLogs(pod, &corev1.PodLogOptions{
+		Container: ec.Instance,
+		Follow:    true,
+	})
+	podLogs, err := req.Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer podLogs.Close()
+
+	// k8s doesn't support separate stdout/stderr :(
 	if _, err = io.Copy(stdout, podLogs); err != nil {
-		fmt.Fprintln(stderr, "error writing logs: %v", err)
+		fmt.Fprintf(stderr, "error writing logs: %v\n", err)
 	}
 	fmt.Println("done reading logs!")
 
 	return err
 }
 
-func (b *Backend) waitForReady(ctx context.Context, namespace, pod, container string) (*corev1.ContainerStatus, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+// attach connects the given streams to a running container via the pods/attach subresource.
+func (b *Backend) attach(ctx context.Context, ns, pod, container string, opts remotecommand.StreamOptions) error {
+	req := b.client.CoreV1().RESTClient().Post().Resource("pods").Name(pod).Namespace(ns).SubResource("attach")
+	req.VersionedParams(&corev1.PodAttachOptions{
+		Container: container,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    opts.Stdout != nil,
+		Stderr:    opts.Stderr != nil,
+		TTY:       opts.Tty,
+	}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(b.config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("NewSPDYExecutor: %w", err)
+	}
+	if err := exec.StreamWithContext(ctx, opts); err != nil {
+		return fmt.Errorf("StreamWithContext: %w", err)
+	}
+	return nil
+}
 
+// getEphemeralContainer returns the pod and the named ephemeral container.
+func (b *Backend) getEphemeralContainer(ctx context.Context, ns, podName, container string) (*corev1.Pod, podContainer, error) {
+	pod, c, err := b.getContainer(ctx, ns, podName, container)
+	if err != nil {
+		return nil, podContainer{}, err
+	}
+	if !c.Ephemeral {
+		return nil, podContainer{}, errdefs.NotFound(fmt.Errorf("container %s not found", container))
+	}
+	return pod, c, nil
+}
+
+// waitForReady waits for the container to be running or terminated, failing
+// if it can't be started or doesn't start within the configured timeout.
+func (b *Backend) waitForReady(ctx context.Context, namespace, pod, container string) (*corev1.ContainerStatus, error) {
 	if namespace == "" {
 		namespace = "default"
 	}
-	for {
-		if ctx.Err() != nil {
-			return nil, ctx.Err()
+	ctx, cancel := context.WithTimeout(ctx, b.startTimeout)
+	defer cancel()
+
+	status, err := b.waitForContainer(ctx, namespace, pod, container, func(s *corev1.ContainerStatus) bool {
+		return s.State.Running != nil || s.State.Terminated != nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, errdefs.Deadline(fmt.Errorf("container %s did not start within %s", container, b.startTimeout))
+	}
+	return status, err
+}
+
+// waitForContainer waits until done returns true for the status of the given
+// container, failing early if the container can't be started.
+func (b *Backend) waitForContainer(ctx context.Context, namespace, pod, container string, done func(*corev1.ContainerStatus) bool) (*corev1.ContainerStatus, error) {
+	var status *corev1.ContainerStatus
+	_, err := b.pods.WaitFor(ctx, namespace, pod, func(p *corev1.Pod) (bool, error) {
+		if p == nil {
+			return false, errdefs.NotFound(fmt.Errorf("pod %s/%s not found", namespace, pod))
 		}
-		pod, err := b.client.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
-		if err != nil {
-			return nil, err
+		status = containerStatus(p, container)
+		if status == nil {
+			return false, nil
 		}
-		for _, c := range pod.Status.EphemeralContainerStatuses {
-			if c.Name == container {
-				if c.State.Running != nil || c.State.Terminated != nil {
-					return &c, nil
-				}
-			}
+		if done(status) {
+			return true, nil
 		}
-		time.Sleep(1 * time.Second)
+		return false, b.startError(ctx, p, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ephemeralContainerStatus returns a copy of the named ephemeral container's
+// status, or nil if it has none yet.
+func ephemeralContainerStatus(pod *corev1.Pod, container string) *corev1.ContainerStatus {
+	for _, c := range pod.Status.EphemeralContainerStatuses {
+		if c.Name == container {
+			return c.DeepCopy()
+		}
+	}
+	return nil
+}
+
+// startFailures maps the waiting reasons of containers that won't start
+// without intervention to the docker error they are reported as.
+var startFailures = map[string]func(error) error{
+	"ErrImagePull":               errdefs.NotFound,
+	"ImagePullBackOff":           errdefs.NotFound,
+	"ErrImageNeverPull":          errdefs.NotFound,
+	"InvalidImageName":           errdefs.InvalidParameter,
+	"CreateContainerConfigError": errdefs.InvalidParameter,
+	"CreateContainerError":       errdefs.System,
+	"RunContainerError":          errdefs.System,
+}
+
+// startError returns an error if the container is stuck waiting for a reason
+// that will not resolve on its own, or nil if it may still start.
+func (b *Backend) startError(ctx context.Context, pod *corev1.Pod, status *corev1.ContainerStatus) error {
+	waiting := status.State.Waiting
+	if waiting == nil {
+		return nil
+	}
+	errType, ok := startFailures[waiting.Reason]
+	if !ok {
+		return nil
+	}
+	msg := waiting.Message
+	if msg == "" {
+		msg = b.lastWarning(ctx, pod, status.Name)
+	}
+	return errType(fmt.Errorf("container %s failed to start: %s: %s", status.Name, waiting.Reason, msg))
+}
+
+// lastWarning returns the message of the most recent warning event recorded
+// against the given k8s container, if any.
+func (b *Backend) lastWarning(ctx context.Context, pod *corev1.Pod, container string) string {
+	events, err := b.client.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.name": pod.Name,
+			"involvedObject.uid":  string(pod.UID),
+			"type":                corev1.EventTypeWarning,
+		}.String(),
+	})
+	if err != nil {
+		fmt.Println("error listing events:", err)
+		return ""
+	}
+
+	c, ok := findInstance(pod, container)
+	if !ok {
+		return ""
+	}
+	fieldPath := c.fieldPath()
+	var latest *corev1.Event
+	for i := range events.Items {
+		e := &events.Items[i]
+		if e.InvolvedObject.FieldPath != fieldPath {
+			continue
+		}
+		if latest == nil || latest.LastTimestamp.Before(&e.LastTimestamp) {
+			latest = e
+		}
+	}
+	if latest == nil {
+		return ""
 	}
+	return latest.Message
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/backend"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestAttachTerminalSize(t *testing.T) {
+	pod := testPod("ns", "pod", "web")
+	pod.Spec.EphemeralContainers[0].TTY = true
+	pod.Spec.EphemeralContainers[0].Stdin = true
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","consoleSize":[24,80]}}`,
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	b.startTimeout = 5 * time.Second
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}.String()
+
+	// The client resizes as soon as it has its streams, before the attach
+	// session is set up.
+	errStop := errors.New("stop")
+	var got []remotecommand.TerminalSize
+	err := b.ContainerAttach(web, &backend.ContainerAttachConfig{
+		UseStdin:  true,
+		UseStdout: true,
+		GetStreams: func(bool) (io.ReadCloser, io.Writer, io.Writer, error) {
+			var q *sizeQueue
+			for open := range b.terminals.sessions[web] {
+				q = open
+			}
+			if q == nil {
+				t.Fatal("no terminal session open when the streams were requested")
+			}
+			got = append(got, *q.Next())
+			if err := b.ContainerResize(web, 30, 100); err != nil {
+				t.Errorf("ContainerResize() = %v", err)
+			}
+			got = append(got, *q.Next())
+			return nil, nil, nil, errStop
+		},
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("ContainerAttach() = %v, want the streams' error", err)
+	}
+
+	want := []remotecommand.TerminalSize{{Height: 24, Width: 80}, {Height: 30, Width: 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sizes = %v, want %v", got, want)
+	}
+	if len(b.terminals.sessions) != 0 {
+		t.Errorf("ContainerAttach() left %d sessions open", len(b.terminals.sessions))
+	}
+}
@@ -1,14 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
+// Copies are done with tar and stat inside the container, the same way
+// kubectl cp works, so the image needs to have both.
+
 func (b *Backend) ContainerArchivePath(name string, path string) (content io.ReadCloser, stat *types.ContainerPathStat, err error) {
-	return nil, nil, ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, c, err := b.getContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err = b.statPath(ctx, ref.Namespace, ref.Pod, c.Instance, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir, base := splitArchivePath(path)
+	r, w := io.Pipe()
+	go func() {
+		var stderr bytes.Buffer
+		err := b.exec(ctx, ref.Namespace, ref.Pod, c.Instance, []string{"tar", "cf", "-", "-C", dir, base}, remotecommand.StreamOptions{
+			Stdout: w,
+			Stderr: &stderr,
+		})
+		w.CloseWithError(copyError(err, &stderr))
+	}()
+	return r, stat, nil
 }
 
 func (b *Backend) ContainerExport(ctx context.Context, name string, out io.Writer) error {
@@ -16,9 +53,155 @@ func (b *Backend) ContainerExport(ctx context.Context, name string, out io.Write
 }
 
 func (b *Backend) ContainerExtractToDir(name, path string, copyUIDGID, noOverwriteDirNonDir bool, content io.Reader) error {
-	return ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	_, c, err := b.getContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	stat, err := b.statPath(ctx, ref.Namespace, ref.Pod, c.Instance, path)
+	if err != nil {
+		return err
+	}
+	if !stat.Mode.IsDir() {
+		return errdefs.InvalidParameter(fmt.Errorf("extraction point is not a directory"))
+	}
+
+	cmd := []string{"tar", "xf", "-", "-C", path}
+	if !copyUIDGID {
+		// Like docker, files are owned by the container's user unless asked
+		// otherwise.
+		cmd = append(cmd, "-o")
+	}
+	var stderr bytes.Buffer
+	err = b.exec(ctx, ref.Namespace, ref.Pod, c.Instance, cmd, remotecommand.StreamOptions{
+		Stdin:  content,
+		Stderr: &stderr,
+	})
+	return copyError(err, &stderr)
 }
 
 func (b *Backend) ContainerStatPath(name string, path string) (stat *types.ContainerPathStat, err error) {
-	return nil, ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return nil, err
+	}
+	_, c, err := b.getContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	return b.statPath(ctx, ref.Namespace, ref.Pod, c.Instance, path)
+}
+
+// statScript prints the size, raw mode and modification time of $1, followed
+// by its resolved target if it's a symlink.
+const statScript = `stat -c '%s %f %Y' -- "$1" && if [ -L "$1" ]; then readlink -f -- "$1"; fi`
+
+// statPath stats path in the container.
+func (b *Backend) statPath(ctx context.Context, namespace, pod, container, p string) (*types.ContainerPathStat, error) {
+	var stdout, stderr bytes.Buffer
+	err := b.exec(ctx, namespace, pod, container, []string{"sh", "-c", statScript, "sh", p}, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err := copyError(err, &stderr); err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(stdout.String()), "\n", 2)
+	fields := strings.Fields(lines[0])
+	if len(fields) != 3 {
+		return nil, errdefs.System(fmt.Errorf("unexpected stat output: %q", stdout.String()))
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, errdefs.System(fmt.Errorf("unexpected stat output: %q", stdout.String()))
+	}
+	mode, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return nil, errdefs.System(fmt.Errorf("unexpected stat output: %q", stdout.String()))
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, errdefs.System(fmt.Errorf("unexpected stat output: %q", stdout.String()))
+	}
+
+	stat := &types.ContainerPathStat{
+		Name:  path.Base(p),
+		Size:  size,
+		Mode:  fileMode(uint32(mode)),
+		Mtime: time.Unix(mtime, 0),
+	}
+	if len(lines) == 2 {
+		stat.LinkTarget = strings.TrimSpace(lines[1])
+	}
+	return stat, nil
+}
+
+// splitArchivePath returns the directory to archive from and the entry to
+// archive, so the archive's root entry is named after the requested path.
+func splitArchivePath(p string) (string, string) {
+	p = path.Clean(p)
+	if p == "/" {
+		return "/", "."
+	}
+	return path.Dir(p), path.Base(p)
+}
+
+// copyError turns a failed copy command into an error, using its stderr as
+// the message.
+func copyError(err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if _, ok := exitStatus(err); !ok || msg == "" {
+		return err
+	}
+	if strings.Contains(msg, "No such file or directory") {
+		return errdefs.NotFound(fmt.Errorf("Could not find the file in container: %s", msg))
+	}
+	return errdefs.System(fmt.Errorf("%s", msg))
+}
+
+// fileMode converts a unix st_mode into an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	const (
+		typeMask = 0170000
+		setuid   = 04000
+		setgid   = 02000
+		sticky   = 01000
+	)
+
+	m := os.FileMode(mode & 0777)
+	switch mode & typeMask {
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	case 0010000:
+		m |= os.ModeNamedPipe
+	case 0140000:
+		m |= os.ModeSocket
+	case 0020000:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		m |= os.ModeDevice
+	}
+	if mode&setuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&setgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&sticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
 }
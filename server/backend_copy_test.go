@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode uint32
+		want os.FileMode
+	}{
+		{mode: 0100644, want: 0644},
+		{mode: 0040755, want: os.ModeDir | 0755},
+		{mode: 0120777, want: os.ModeSymlink | 0777},
+		{mode: 0041777, want: os.ModeDir | os.ModeSticky | 0777},
+		{mode: 0104755, want: os.ModeSetuid | 0755},
+		{mode: 0020666, want: os.ModeDevice | os.ModeCharDevice | 0666},
+	} {
+		if got := fileMode(tc.mode); got != tc.want {
+			t.Errorf("fileMode(%o) = %v, want %v", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, dir, base string
+	}{
+		{path: "/etc/hosts", dir: "/etc", base: "hosts"},
+		{path: "/workspace/out/", dir: "/workspace", base: "out"},
+		{path: "/", dir: "/", base: "."},
+	} {
+		dir, base := splitArchivePath(tc.path)
+		if dir != tc.dir || base != tc.base {
+			t.Errorf("splitArchivePath(%s) = %s, %s, want %s, %s", tc.path, dir, base, tc.dir, tc.base)
+		}
+	}
+}
@@ -3,216 +3,260 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stringid"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/rand"
-	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
-)
-
-var (
-	// TODO: make this a TTL cache
-	state = map[string]*ExecState{}
+	utilexec "k8s.io/client-go/util/exec"
 )
 
 type ExecState struct {
-	cfg *types.ExecConfig
+	mu        sync.Mutex
+	id        string
+	container containerRef
+	cfg       *types.ExecConfig
 
 	running  bool
 	exitCode *int
+	updated  time.Time
 }
 
-func (b *Backend) ContainerExecCreate(name string, config *types.ExecConfig) (string, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+// start marks the exec as running.
+func (s *ExecState) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+	s.exitCode = nil
+}
+
+// finish marks the exec as no longer running with the given exit code.
+func (s *ExecState) finish(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.exitCode = &code
+}
+
+// status returns whether the exec is running and its exit code, if finished.
+func (s *ExecState) status() (bool, *int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.exitCode
+}
+
+func (s *ExecState) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updated = time.Now()
+}
+
+func (s *ExecState) lastUpdated() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updated
+}
 
-	//ctx := context.TODO()
+// record returns a snapshot of the exec suitable for persisting.
+func (s *ExecState) record() *execRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &execRecord{
+		ID:        s.id,
+		Container: s.container.String(),
+		Config: &types.ExecConfig{
+			User:         s.cfg.User,
+			Privileged:   s.cfg.Privileged,
+			Tty:          s.cfg.Tty,
+			AttachStdin:  s.cfg.AttachStdin,
+			AttachStderr: s.cfg.AttachStderr,
+			AttachStdout: s.cfg.AttachStdout,
+			Cmd:          s.cfg.Cmd,
+		},
+		Running:  s.running,
+		ExitCode: s.exitCode,
+		Updated:  s.updated,
+	}
+}
+
+// exitStatus extracts the exit code of the remote process from a remotecommand
+// stream error. A nil error means the process exited cleanly.
+func exitStatus(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
 
+func (b *Backend) ContainerExecCreate(name string, config *types.ExecConfig) (string, error) {
 	fmt.Println(name)
 	json.NewEncoder(os.Stdout).Encode(config)
 
-	/*
-		s := strings.Split(name, ".")
-		if len(s) != 3 {
-			return "", fmt.Errorf("invalid container name %q", name)
-		}
-
-			_, _, image := s[0], s[1], s[2]
-			if image == "buildx_buildkit_default" {
-				image = "moby/buildkit"
-			}
-	*/
-
-	/*
-		pod, err := b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
-		if err != nil {
-			return "", err
-		}
-			ec := corev1.EphemeralContainer{
-				EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-					Name:  fmt.Sprintf("levias-%s", rand.String(8)),
-					Image: image,
-					Args:  config.Cmd,
-				},
-			}
-			pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, ec)
-
-			out, err := b.client.CoreV1().Pods(ns).UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{})
-			if err != nil {
-				return "", err
-			}
-
-			return strings.Join([]string{out.Namespace, out.Name, ec.Name}, "."), nil
-	*/
-
-	id := fmt.Sprintf("%s.%s", name, rand.String(8))
-	state[id] = &ExecState{
-		cfg: config,
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return "", err
+	}
+	pod, c, err := b.getContainer(context.TODO(), ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return "", err
 	}
+	if isPaused(pod, c) {
+		return "", errdefs.Conflict(fmt.Errorf("Container %s is paused, unpause the container before exec", ref.ID()))
+	}
+
+	id := stringid.GenerateRandomID()
+	if _, err := b.execs.Add(context.TODO(), id, ref, config); err != nil {
+		return "", err
+	}
+	b.events.logExec(ref, id, config.Cmd, events.ActionExecCreate, nil)
 
 	return id, nil
 }
 
 func (b *Backend) ContainerExecInspect(id string) (*backend.ExecInspect, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	//ctx := context.TODO()
+	s, err := b.execs.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	running, exitCode := s.status()
 
-	s, ok := state[id]
-	if !ok {
-		return nil, fmt.Errorf("exec config not found for %q", id)
+	var entrypoint string
+	var args []string
+	if len(s.cfg.Cmd) > 0 {
+		entrypoint, args = s.cfg.Cmd[0], s.cfg.Cmd[1:]
 	}
 	return &backend.ExecInspect{
 		ID:       id,
-		ExitCode: s.exitCode,
-		Running:  false,
+		ExitCode: exitCode,
+		Running:  running,
+		ProcessConfig: &backend.ExecProcessConfig{
+			Tty:        s.cfg.Tty,
+			Entrypoint: entrypoint,
+			Arguments:  args,
+			Privileged: &s.cfg.Privileged,
+			User:       s.cfg.User,
+		},
+		OpenStdin:   s.cfg.AttachStdin,
+		OpenStdout:  s.cfg.AttachStdout,
+		OpenStderr:  s.cfg.AttachStderr,
+		ContainerID: s.container.ID(),
 	}, nil
-
-	/*
-		s := strings.Split(id, ".")
-		if len(s) != 4 {
-			return nil, fmt.Errorf("invalid container name %q", id)
-		}
-		ns, podName, image, _ := s[0], s[1], s[2], s[3]
-
-		pod, err := b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
-		if err != nil {
-			return nil, err
-		}
-
-		for _, ec := range pod.Status.EphemeralContainerStatuses {
-			fmt.Println("@@@", ec.Name, image)
-			if ec.Name == image {
-				var exitCode *int
-				if ec.State.Terminated != nil {
-					i := int(ec.State.Terminated.ExitCode)
-					exitCode = &i
-				}
-				return &backend.ExecInspect{
-					Running:     *ec.Started,
-					ID:          id,
-					ExitCode:    exitCode,
-					ContainerID: ec.ContainerID,
-				}, nil
-			}
-		}
-		return nil, fmt.Errorf("ephemeral container %q not found", image)
-	*/
 }
 
-func (b *Backend) ContainerExecResize(name string, height, width int) error { return ErrUnimplemented }
+func (b *Backend) ContainerExecResize(name string, height, width int) error {
+	b.terminals.resize(name, height, width)
+	return nil
+}
 
 func (b *Backend) ContainerExecStart(ctx context.Context, name string, options container.ExecStartOptions) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
 	log.Println("ContainerExecStart", name)
-	s := strings.Split(name, ".")
-	if len(s) != 4 {
-		return fmt.Errorf("invalid container name %q", name)
-	}
-	ns, pod, container, execID := s[0], s[1], s[2], s[3]
 
-	fmt.Println("$", ns, pod, container, execID)
-
-	state, ok := state[name]
-	if !ok {
-		return fmt.Errorf("exec config not found for %q", name)
+	state, err := b.execs.Get(name)
+	if err != nil {
+		return err
 	}
+	ns, pod, container := state.container.Namespace, state.container.Pod, state.container.Name
 
-	req := b.client.CoreV1().RESTClient().Post().Resource("pods").Name(pod).Namespace(ns).SubResource("exec")
-	req.VersionedParams(&corev1.PodExecOptions{
-		Container: container,
-		Stdin:     options.Stdin != nil,
-		Stdout:    options.Stdout != nil,
-		Stderr:    options.Stderr != nil,
-		Command:   state.cfg.Cmd,
-	}, scheme.ParameterCodec)
-	exec, err := remotecommand.NewSPDYExecutor(b.config, "POST", req.URL())
+	// The container may have been restarted or paused since the exec was
+	// created.
+	p, c, err := b.getContainer(ctx, ns, pod, container)
 	if err != nil {
-		return fmt.Errorf("NewSPDYExecutor: %w", err)
+		return err
 	}
-
-	timeout := int64(60)
-	watcher, err := b.client.CoreV1().Pods(ns).Watch(ctx, metav1.ListOptions{
-		TimeoutSeconds: &timeout,
-		FieldSelector:  fmt.Sprintf("metadata.name=%s", pod),
-	})
-	defer watcher.Stop()
-	if ok, err := waitForEphemeralContainer(watcher, container); err != nil {
-		return fmt.Errorf("waitForEphemeralContainer: %w", err)
-	} else if !ok {
-		return fmt.Errorf("ephemeral container %q not running", container)
+	if isPaused(p, c) {
+		return errdefs.Conflict(fmt.Errorf("Container %s is paused, unpause the container before exec", state.container.ID()))
+	}
+	status, err := b.waitForReady(ctx, ns, pod, c.Instance)
+	if err != nil {
+		return err
+	}
+	if status.State.Running == nil {
+		return errdefs.Conflict(fmt.Errorf("container %s is not running", container))
 	}
 
-	if err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+	opts := remotecommand.StreamOptions{
 		Stdin:  options.Stdin,
 		Stdout: options.Stdout,
 		Stderr: options.Stderr,
-	}); err != nil {
-		// TODO: can we grab the exit code from the exec status?
-		i := 1
-		state.exitCode = &i
-		return fmt.Errorf("StreamWithContext: %w", err)
+		Tty:    state.cfg.Tty,
 	}
+	if state.cfg.Tty {
+		q := b.terminals.open(name, options.ConsoleSize)
+		defer b.terminals.close(name, q)
+		opts.TerminalSizeQueue = q
+	}
+
+	return b.runExec(ctx, state, func() error {
+		return b.exec(ctx, ns, pod, c.Instance, state.cfg.Cmd, opts)
+	})
+}
 
+// runExec records the exec session as running for as long as run does, then
+// records the exit code of the process it ran.
+func (b *Backend) runExec(ctx context.Context, state *ExecState, run func() error) error {
+	state.start()
+	if err := b.execs.Save(ctx, state); err != nil {
+		log.Printf("error saving exec %s: %v", state.id, err)
+	}
+	b.events.logExec(state.container, state.id, state.cfg.Cmd, events.ActionExecStart, nil)
+	err := run()
+	code, ok := exitStatus(err)
+	if !ok {
+		// The command never ran to completion, which docker reports as 126.
+		code = 126
+	}
+	state.finish(code)
+	if err := b.execs.Save(ctx, state); err != nil {
+		log.Printf("error saving exec %s: %v", state.id, err)
+	}
+	b.events.logExec(state.container, state.id, state.cfg.Cmd, events.ActionExecDie, map[string]string{
+		"exitCode": strconv.Itoa(code),
+	})
+	if !ok {
+		return fmt.Errorf("StreamWithContext: %w", err)
+	}
 	return nil
 }
 
-func waitForEphemeralContainer(watcher watch.Interface, container string) (bool, error) {
-	running := false
-	for event := range watcher.ResultChan() {
-		log.Println(event)
-		switch event.Type {
-		case watch.Added, watch.Modified:
-			pod := event.Object.(*corev1.Pod)
-			for _, ec := range pod.Status.EphemeralContainerStatuses {
-				if ec.Name == container {
-					log.Printf("Ephemeral container %q status: %+v\n", container, ec.State)
-					running = ec.State.Running != nil
-				}
-			}
-		case watch.Deleted:
-			return false, nil
-		case watch.Error:
-			fmt.Errorf("Unexpected error watching pod: %w", event)
-		}
-
-		if running {
-			return true, nil
-		}
-	}
-	return running, nil
+// exec runs cmd in the container via the pods/exec subresource, connecting
+// the given streams. Errors from the remote process can be inspected with
+// exitStatus.
+func (b *Backend) exec(ctx context.Context, namespace, pod, container string, cmd []string, opts remotecommand.StreamOptions) error {
+	req := b.client.CoreV1().RESTClient().Post().Resource("pods").Name(pod).Namespace(namespace).SubResource("exec")
+	req.VersionedParams(&corev1.PodExecOptions{
+		Container: container,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    opts.Stdout != nil,
+		Stderr:    opts.Stderr != nil,
+		TTY:       opts.Tty,
+		Command:   cmd,
+	}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(b.config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("NewSPDYExecutor: %w", err)
+	}
+	return exec.StreamWithContext(ctx, opts)
 }
 
-func (b *Backend) ExecExists(name string) (bool, error) { return true, nil }
+func (b *Backend) ExecExists(name string) (bool, error) {
+	if _, err := b.execs.Get(name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
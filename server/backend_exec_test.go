@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestExitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		err      error
+		wantCode int
+		wantOK   bool
+	}{
+		{desc: "clean exit", wantCode: 0, wantOK: true},
+		{desc: "exit code", err: fmt.Errorf("stream: %w", utilexec.CodeExitError{Err: errors.New("command terminated with exit code 42"), Code: 42}), wantCode: 42, wantOK: true},
+		{desc: "stream error", err: errors.New("connection reset by peer")},
+	} {
+		code, ok := exitStatus(tc.err)
+		if code != tc.wantCode || ok != tc.wantOK {
+			t.Errorf("%s: exitStatus(%v) = %d, %t, want %d, %t", tc.desc, tc.err, code, ok, tc.wantCode, tc.wantOK)
+		}
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		err      error
+		wantCode int
+		wantErr  bool
+	}{
+		{desc: "clean exit", wantCode: 0},
+		{desc: "exit code", err: utilexec.CodeExitError{Err: errors.New("command terminated with exit code 42"), Code: 42}, wantCode: 42},
+		// Commands that never ran to completion are reported like docker
+		// reports commands it can't run.
+		{desc: "stream error", err: errors.New("connection reset by peer"), wantCode: 126, wantErr: true},
+	} {
+		b := newTestBackend(t, testPod("ns", "pod", "web"))
+		ctx := context.Background()
+		ref := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+		state, err := b.execs.Add(ctx, "exec", ref, &types.ExecConfig{Cmd: []string{"ls"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if running, exitCode := state.status(); running || exitCode != nil {
+			t.Errorf("%s: status() before start = %t, %v, want created", tc.desc, running, exitCode)
+		}
+
+		err = b.runExec(ctx, state, func() error {
+			if running, exitCode := state.status(); !running || exitCode != nil {
+				t.Errorf("%s: status() while running = %t, %v, want running", tc.desc, running, exitCode)
+			}
+			return tc.err
+		})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: runExec() = %v, want error %t", tc.desc, err, tc.wantErr)
+		}
+
+		inspect, err := b.ContainerExecInspect("exec")
+		if err != nil {
+			t.Fatalf("%s: ContainerExecInspect() = %v", tc.desc, err)
+		}
+		if inspect.Running || inspect.ExitCode == nil || *inspect.ExitCode != tc.wantCode {
+			t.Errorf("%s: inspect = running %t, exit code %v, want exited with %d", tc.desc, inspect.Running, inspect.ExitCode, tc.wantCode)
+		}
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/api/types/container"
+	timetypes "github.com/docker/docker/api/types/time"
 	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/archive"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -18,7 +25,7 @@ func (b *Backend) ContainerChanges(ctx context.Context, name string) ([]archive.
 }
 func (b *Backend) ContainerInspect(ctx context.Context, name string, size bool, version string) (interface{}, error) {
 	// Return synthetic container for buildkit - we'll spin this up on demand later.
-	if strings.HasSuffix(name, ".buildx_buildkit_default") {
+	if strings.HasSuffix(name, "/buildx_buildkit_default") {
 		return types.ContainerJSON{
 			ContainerJSONBase: &types.ContainerJSONBase{
 				Name: name,
@@ -28,10 +35,145 @@ func (b *Backend) ContainerInspect(ctx context.Context, name string, size bool,
 			},
 		}, nil
 	}
-	return nil, errdefs.NotFound(fmt.Errorf("container %s not found", name))
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(ctx, ref.Namespace, ref.Pod, name); err != nil {
+		return nil, err
+	}
+	pod, c, err := b.getContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	return containerJSON(pod, c), nil
+}
+func (b *Backend) ContainerLogs(ctx context.Context, name string, config *container.LogsOptions) (<-chan *backend.LogMessage, bool, error) {
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return nil, false, err
+	}
+	ns, pod, container := ref.Namespace, ref.Pod, ref.Name
+	if err := checkAccess(ctx, ns, pod, name); err != nil {
+		return nil, false, err
+	}
+
+	_, c, err := b.getContainer(ctx, ns, pod, container)
+	if err != nil {
+		return nil, false, err
+	}
+
+	opts, until, err := podLogOptions(c.Instance, config)
+	if err != nil {
+		return nil, false, errdefs.InvalidParameter(err)
+	}
+
+	podLogs, err := b.client.CoreV1().Pods(ns).GetLogs(pod, opts).Stream(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	msgs := make(chan *backend.LogMessage)
+	go func() {
+		defer close(msgs)
+		defer podLogs.Close()
+		readLogs(ctx, podLogs, until, msgs)
+	}()
+
+	// k8s doesn't support separate stdout/stderr, so everything is reported as stdout.
+	return msgs, c.TTY, nil
 }
-func (b *Backend) ContainerLogs(ctx context.Context, name string, config *container.LogsOptions) (msgs <-chan *backend.LogMessage, tty bool, err error) {
-	return nil, false, ErrUnimplemented
+
+// readLogs sends the lines of a timestamped k8s log stream to msgs, stopping
+// at the first line logged after until (if set).
+func readLogs(ctx context.Context, r io.Reader, until time.Time, msgs chan<- *backend.LogMessage) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			msg := parseLogLine(line)
+			if !until.IsZero() && msg.Timestamp.After(until) {
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				select {
+				case msgs <- &backend.LogMessage{Err: err}:
+				case <-ctx.Done():
+				}
+			}
+			return
+		}
+	}
+}
+
+// podLogOptions translates docker log options into the k8s equivalent.
+// k8s has no notion of an end time, so until is returned separately to be
+// filtered on as lines come in.
+func podLogOptions(container string, config *container.LogsOptions) (*corev1.PodLogOptions, time.Time, error) {
+	opts := &corev1.PodLogOptions{
+		Container: container,
+		Follow:    config.Follow,
+		// Always request timestamps so we can populate LogMessage.Timestamp and
+		// filter on until. The docker API handles adding them back to the output.
+		Timestamps: true,
+	}
+
+	if config.Tail != "" && config.Tail != "all" {
+		n, err := strconv.ParseInt(config.Tail, 10, 64)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid tail value %q: %w", config.Tail, err)
+		}
+		if n >= 0 {
+			opts.TailLines = &n
+		}
+	}
+
+	if config.Since != "" {
+		s, n, err := timetypes.ParseTimestamps(config.Since, 0)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid since value %q: %w", config.Since, err)
+		}
+		since := metav1.NewTime(time.Unix(s, n))
+		opts.SinceTime = &since
+	}
+
+	var until time.Time
+	if config.Until != "" {
+		s, n, err := timetypes.ParseTimestamps(config.Until, 0)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid until value %q: %w", config.Until, err)
+		}
+		until = time.Unix(s, n)
+	}
+
+	return opts, until, nil
+}
+
+// parseLogLine splits a timestamped k8s log line ("<RFC3339Nano> <line>") into a LogMessage.
+func parseLogLine(line []byte) *backend.LogMessage {
+	msg := &backend.LogMessage{
+		Line:   line,
+		Source: "stdout",
+	}
+	ts, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return msg
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(ts))
+	if err != nil {
+		return msg
+	}
+	msg.Line = rest
+	msg.Timestamp = t
+	return msg
 }
 func (b *Backend) ContainerStats(ctx context.Context, name string, config *backend.ContainerStatsConfig) error {
 	return ErrUnimplemented
@@ -40,9 +182,6 @@ func (b *Backend) ContainerTop(name string, psArgs string) (*container.Container
 	return nil, ErrUnimplemented
 }
 func (b *Backend) Containers(ctx context.Context, config *container.ListOptions) ([]*types.Container, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
 	ns, pod, err := getPod(ctx)
 	if err != nil {
 		return nil, err
@@ -51,17 +190,48 @@ func (b *Backend) Containers(ctx context.Context, config *container.ListOptions)
 	if err != nil {
 		return nil, err
 	}
-	out := make([]*types.Container, 0, len(pods.Spec.EphemeralContainers))
-	for i, ec := range pods.Spec.EphemeralContainers {
-		status := pods.Status.EphemeralContainerStatuses[i]
-		out = append(out, &types.Container{
-			ID:      strings.Join([]string{ns, pod, ec.Name}, "."),
-			Names:   []string{ec.Name},
-			Image:   ec.Image,
-			State:   status.State.String(),
-			ImageID: status.ImageID,
-			Command: strings.Join(ec.Command, " "),
-		})
+
+	entries := listEntries(pods)
+	filter, err := newListFilter(entries, config)
+	if err != nil {
+		return nil, err
+	}
+	limit := config.Limit
+	if config.Latest {
+		limit = 1
+	}
+
+	// docker ps --size is accepted, but no sizes are reported: only the
+	// kubelet knows them, through an API that gives access to whole nodes.
+
+	networks := networkSettings(pods).Networks
+	now := time.Now()
+	out := []*types.Container{}
+	for _, e := range entries {
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+		if !filter.include(e) {
+			continue
+		}
+		path, args := splitCommand(e.Command, e.Args)
+		c := &types.Container{
+			ID:      e.ref.ID(),
+			Names:   []string{"/" + e.DockerName},
+			Image:   e.Image,
+			ImageID: imageID(e.status),
+			Command: strings.TrimSpace(strings.Join(append([]string{path}, args...), " ")),
+			Created: e.created.Unix(),
+			Labels:  e.labels,
+			State:   e.state.Status,
+			Status:  humanStatus(e.status, e.state, now),
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: networks,
+			},
+			Mounts: mountPoints(pods, e.VolumeMounts),
+		}
+		c.HostConfig.NetworkMode = "default"
+		out = append(out, c)
 	}
 	return out, nil
 }
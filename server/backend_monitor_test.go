@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestPodLogOptions(t *testing.T) {
+	ten := int64(10)
+	for _, tc := range []struct {
+		desc      string
+		config    container.LogsOptions
+		wantTail  *int64
+		wantSince time.Time
+		wantUntil time.Time
+		wantErr   bool
+	}{
+		{desc: "defaults"},
+		{desc: "follow", config: container.LogsOptions{Follow: true}},
+		{desc: "tail", config: container.LogsOptions{Tail: "10"}, wantTail: &ten},
+		{desc: "tail all", config: container.LogsOptions{Tail: "all"}},
+		{desc: "negative tail", config: container.LogsOptions{Tail: "-1"}},
+		{desc: "invalid tail", config: container.LogsOptions{Tail: "ten"}, wantErr: true},
+		{desc: "since", config: container.LogsOptions{Since: "1700000000"}, wantSince: time.Unix(1700000000, 0)},
+		{desc: "since with nanoseconds", config: container.LogsOptions{Since: "1700000000.5"}, wantSince: time.Unix(1700000000, 500000000)},
+		{desc: "invalid since", config: container.LogsOptions{Since: "yesterday"}, wantErr: true},
+		{desc: "until", config: container.LogsOptions{Until: "1700000100"}, wantUntil: time.Unix(1700000100, 0)},
+		{desc: "invalid until", config: container.LogsOptions{Until: "tomorrow"}, wantErr: true},
+	} {
+		opts, until, err := podLogOptions("web", &tc.config)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: podLogOptions() = %v, want error %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if opts.Container != "web" || opts.Follow != tc.config.Follow {
+			t.Errorf("%s: podLogOptions() = %+v, want container web, follow %t", tc.desc, opts, tc.config.Follow)
+		}
+		// Timestamps are always requested, to filter on until.
+		if !opts.Timestamps {
+			t.Errorf("%s: podLogOptions() doesn't request timestamps", tc.desc)
+		}
+		if !reflect.DeepEqual(opts.TailLines, tc.wantTail) {
+			t.Errorf("%s: TailLines = %v, want %v", tc.desc, opts.TailLines, tc.wantTail)
+		}
+		switch {
+		case tc.wantSince.IsZero() && opts.SinceTime != nil:
+			t.Errorf("%s: SinceTime = %v, want none", tc.desc, opts.SinceTime)
+		case !tc.wantSince.IsZero() && (opts.SinceTime == nil || !opts.SinceTime.Time.Equal(tc.wantSince)):
+			t.Errorf("%s: SinceTime = %v, want %v", tc.desc, opts.SinceTime, tc.wantSince)
+		}
+		if !until.Equal(tc.wantUntil) {
+			t.Errorf("%s: until = %v, want %v", tc.desc, until, tc.wantUntil)
+		}
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		wantLine string
+		wantTime time.Time
+	}{
+		{line: "2024-01-02T03:04:05.123456789Z hello world\n", wantLine: "hello world\n", wantTime: time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)},
+		{line: "2024-01-02T03:04:05Z \n", wantLine: "\n", wantTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		// Lines without a timestamp are passed through as is.
+		{line: "hello world\n", wantLine: "hello world\n"},
+		{line: "no-timestamp\n", wantLine: "no-timestamp\n"},
+	} {
+		msg := parseLogLine([]byte(tc.line))
+		if string(msg.Line) != tc.wantLine || !msg.Timestamp.Equal(tc.wantTime) || msg.Source != "stdout" {
+			t.Errorf("parseLogLine(%q) = %q at %v from %s, want %q at %v from stdout", tc.line, msg.Line, msg.Timestamp, msg.Source, tc.wantLine, tc.wantTime)
+		}
+	}
+}
+
+func TestReadLogs(t *testing.T) {
+	logs := "2024-01-02T03:04:05Z one\n" +
+		"2024-01-02T03:04:06Z two\n" +
+		"2024-01-02T03:04:07Z three\n" +
+		"2024-01-02T03:04:08Z four"
+
+	for _, tc := range []struct {
+		desc  string
+		until time.Time
+		want  []string
+	}{
+		{desc: "no until", want: []string{"one\n", "two\n", "three\n", "four"}},
+		{desc: "until", until: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), want: []string{"one\n", "two\n"}},
+		{desc: "until before everything", until: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		msgs := make(chan *backend.LogMessage)
+		go func() {
+			defer close(msgs)
+			readLogs(context.Background(), strings.NewReader(logs), tc.until, msgs)
+		}()
+		var got []string
+		for msg := range msgs {
+			if msg.Err != nil {
+				t.Fatalf("%s: readLogs() = %v", tc.desc, msg.Err)
+			}
+			got = append(got, string(msg.Line))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: readLogs() = %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	containerpkg "github.com/docker/docker/container"
+	"github.com/docker/docker/errdefs"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/rand"
 )
 
 func getPod(ctx context.Context) (string, string, error) {
@@ -28,9 +32,6 @@ func getPod(ctx context.Context) (string, string, error) {
 }
 
 func (b *Backend) ContainerCreate(ctx context.Context, config backend.ContainerCreateConfig) (container.CreateResponse, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
 	json.NewEncoder(os.Stderr).Encode(config)
 
 	ns, podName, err := getPod(ctx)
@@ -38,66 +39,399 @@ func (b *Backend) ContainerCreate(ctx context.Context, config backend.ContainerC
 		return container.CreateResponse{}, err
 	}
 
-	pod, err := b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
+	name, err := newDockerName(config.Name)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+
+	warnings, err := checkCompat(config, b.strict)
 	if err != nil {
 		return container.CreateResponse{}, err
 	}
+	if err := validateRestartPolicy(config.HostConfig); err != nil {
+		return container.CreateResponse{}, err
+	}
+	// Restart policies, --rm and events only apply to pods the informer
+	// watches.
+	if err := b.pods.Track(ctx, ns, podName); err != nil {
+		return container.CreateResponse{}, err
+	}
 
-	ec := corev1.EphemeralContainer{
-		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-			Name:    fmt.Sprintf("levias-%s", rand.String(8)),
-			Image:   config.Config.Image,
-			Command: config.Config.Entrypoint,
-			Args:    config.Config.Cmd,
-		},
+	// Kubernetes holds on to the names of removed and renamed containers, so
+	// a docker name that's free again is given to a container created under
+	// a generated name. So are docker names Kubernetes doesn't accept.
+	ecName := name
+	if err := b.updateNames(ctx, ns, podName, func(pod *corev1.Pod, names map[string]string) error {
+		if c, ok := findDockerName(pod, name); ok {
+			return nameConflict(pod, name, c.Name)
+		}
+		if !isContainerName(name) || nameInUse(pod, name) {
+			ecName = instanceName(kubernetesName(name))
+			names[name] = ecName
+		}
+		return nil
+	}); err != nil {
+		return container.CreateResponse{}, err
 	}
-	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, ec)
 
-	out, err := b.client.CoreV1().Pods(ns).UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{})
+	var ec corev1.EphemeralContainer
+	out, err := b.updateEphemeralContainers(ctx, ns, podName, func(pod *corev1.Pod) error {
+		if c, ok := findDockerName(pod, name); ok {
+			return nameConflict(pod, name, c.Name)
+		}
+		if nameInUse(pod, ecName) {
+			return nameConflict(pod, name, ecName)
+		}
+		// Mounts are resolved against the pod's volumes.
+		var err error
+		ec, err = translateConfig(pod, ecName, config)
+		if err != nil {
+			return err
+		}
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, ec)
+		return nil
+	})
 	if err != nil {
+		if ecName != name {
+			if err := b.updateNames(ctx, ns, podName, func(_ *corev1.Pod, names map[string]string) error {
+				if names[name] == ecName {
+					delete(names, name)
+				}
+				return nil
+			}); err != nil {
+				fmt.Printf("error cleaning up container name %s: %v\n", name, err)
+			}
+		}
 		return container.CreateResponse{}, err
 	}
 
+	// Ephemeral containers can't carry metadata of their own, so anything
+	// without a spec equivalent is kept on the pod. The container already
+	// exists at this point, so failing here would only orphan it.
+	meta := &containerMeta{
+		Created:     time.Now().UTC(),
+		Labels:      config.Config.Labels,
+		User:        config.Config.User,
+		StopSignal:  config.Config.StopSignal,
+		StopTimeout: config.Config.StopTimeout,
+	}
+	if hc := config.HostConfig; hc != nil {
+		if !hc.RestartPolicy.IsNone() {
+			meta.RestartPolicy = &hc.RestartPolicy
+		}
+		meta.AutoRemove = hc.AutoRemove
+		if size := hc.ConsoleSize; size[0] > 0 && size[1] > 0 {
+			meta.ConsoleSize = &size
+		}
+	}
+	if err := b.updateMetadata(ctx, ns, podName, func(m map[string]*containerMeta) error {
+		m[ec.Name] = meta
+		return nil
+	}); err != nil {
+		fmt.Printf("error saving metadata for %s: %v\n", ec.Name, err)
+		warnings = append(warnings, fmt.Sprintf("container metadata (labels, stop signal) could not be saved: %v", err))
+	}
+
 	return container.CreateResponse{
-		ID: strings.Join([]string{out.Namespace, out.Name, ec.Name}, "."),
+		ID:       containerRef{Namespace: out.Namespace, Pod: out.Name, Name: ec.Name}.ID(),
+		Warnings: warnings,
 	}, nil
 }
 
 func (b *Backend) ContainerKill(name string, signal string) error {
-	return ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	sig, err := parseSignal(signal, syscall.SIGKILL)
+	if err != nil {
+		return err
+	}
+	pod, c, err := b.getManagedContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	if status := containerStatus(pod, c.Instance); status == nil || status.State.Running == nil {
+		return errdefs.Conflict(fmt.Errorf("Cannot kill container: %s: Container %s is not running", c.DockerName, ref.ID()))
+	}
+	meta := containerMetadata(pod, c.Name)
+	if killStops(meta, sig) {
+		if err := b.markStopped(ctx, ref, meta); err != nil {
+			return err
+		}
+	}
+
+	b.events.logContainerRef(ref, events.ActionKill, map[string]string{
+		"signal": strconv.Itoa(int(sig)),
+	})
+	if sig == syscall.SIGKILL {
+		// SIGKILL also ends stopped processes, so paused containers don't
+		// need resuming.
+		return b.kill(ctx, ref.Namespace, ref.Pod, c.Instance)
+	}
+	if err := b.signalContainer(ctx, ref.Namespace, ref.Pod, c.Instance, sig); err != nil {
+		return err
+	}
+	// Like docker, a paused container is resumed so it can act on a signal
+	// meant to stop it.
+	if meta.Paused && isStopSignal(meta, sig) {
+		b.events.logContainerRef(ref, events.ActionUnPause, nil)
+		return b.thaw(ctx, ref, c.Instance)
+	}
+	return nil
 }
 
 func (b *Backend) ContainerPause(name string) error {
-	return ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	pod, c, err := b.getManagedContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	if status := containerStatus(pod, c.Instance); status == nil || status.State.Running == nil {
+		return errdefs.Conflict(fmt.Errorf("Container %s is not running", ref.ID()))
+	}
+	if isPaused(pod, c) {
+		return errdefs.Conflict(fmt.Errorf("Container %s is already paused", ref.ID()))
+	}
+
+	if err := b.freeze(ctx, ref, c.Instance); err != nil {
+		return err
+	}
+	b.events.logContainerRef(ref, events.ActionPause, nil)
+	return nil
 }
 
 func (b *Backend) ContainerRename(oldName, newName string) error {
-	return ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(oldName)
+	if err != nil {
+		return err
+	}
+	newName = strings.TrimPrefix(newName, "/")
+	if newName == "" {
+		return errdefs.InvalidParameter(errors.New("Neither old nor new names may be empty"))
+	}
+	if err := validateDockerName(newName); err != nil {
+		return err
+	}
+
+	var (
+		pod *corev1.Pod
+		c   podContainer
+	)
+	if err := b.updateNames(ctx, ref.Namespace, ref.Pod, func(p *corev1.Pod, names map[string]string) error {
+		var ok bool
+		if c, ok = findContainer(p, ref.Name); !ok {
+			return errdefs.NotFound(fmt.Errorf("No such container: %s", ref.Name))
+		}
+		if !c.Ephemeral {
+			return errUnmanaged(ref.Name, ref.Pod)
+		}
+		if c.DockerName == newName {
+			return errdefs.InvalidParameter(errors.New("Renaming a container with the same name as its current name"))
+		}
+		if other, ok := findDockerName(p, newName); ok {
+			return nameConflict(p, newName, other.Name)
+		}
+		for alias, target := range names {
+			if target == ref.Name {
+				delete(names, alias)
+			}
+		}
+		if newName != ref.Name {
+			names[newName] = ref.Name
+		}
+		pod = p
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	oldDockerName := c.DockerName
+	c.DockerName = newName
+	b.events.logContainer(pod, c, events.ActionRename, map[string]string{
+		"oldName": "/" + oldDockerName,
+	})
+	return nil
 }
 
 func (b *Backend) ContainerResize(name string, height, width int) error {
-	return ErrUnimplemented
+	b.terminals.resize(name, height, width)
+	return nil
 }
 
 func (b *Backend) ContainerRestart(ctx context.Context, name string, options container.StopOptions) error {
-	return ErrUnimplemented
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	if err := b.ContainerStop(ctx, name, options); err != nil {
+		return err
+	}
+	if err := b.startContainer(ctx, ref); err != nil {
+		return err
+	}
+	b.events.logContainerRef(ref, events.ActionRestart, nil)
+	return nil
 }
 
 func (b *Backend) ContainerRm(name string, config *backend.ContainerRmConfig) error {
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	pod, c, err := b.getManagedContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+
+	meta := containerMetadata(pod, c.Name)
+	status := containerStatus(pod, c.Instance)
+	state := containerState(status)
+	markRestarting(state, status, meta)
+	if state.Running || state.Restarting {
+		if !config.ForceRemove {
+			return errdefs.Conflict(fmt.Errorf("cannot remove container %q: container is %s: stop the container before removing or force remove", "/"+c.DockerName, state.Status))
+		}
+		// Keep the restart policy from bringing it back in the meantime.
+		if err := b.markStopped(ctx, ref, meta); err != nil {
+			return err
+		}
+		if state.Running {
+			b.events.logContainerRef(ref, events.ActionKill, map[string]string{
+				"signal": strconv.Itoa(int(syscall.SIGKILL)),
+			})
+			if err := b.kill(ctx, ref.Namespace, ref.Pod, c.Instance); err != nil {
+				return fmt.Errorf("cannot remove container %q: could not kill: %w", "/"+c.DockerName, err)
+			}
+		}
+	}
 
+	// Volumes are the pod's, so there are none to remove with the container.
+	if err := b.removeContainers(ctx, ref.Namespace, ref.Pod, []string{c.Name}); err != nil {
+		return err
+	}
+	b.events.logContainer(pod, c, events.ActionDestroy, nil)
 	return nil
 }
 
 func (b *Backend) ContainerStart(ctx context.Context, name string, checkpoint string, checkpointDir string) error {
-	return nil
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	if err := checkAccess(ctx, ref.Namespace, ref.Pod, name); err != nil {
+		return err
+	}
+	return b.startContainer(ctx, ref)
 }
 
 func (b *Backend) ContainerStop(ctx context.Context, name string, options container.StopOptions) error {
-	return ErrUnimplemented
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	if err := checkAccess(ctx, ref.Namespace, ref.Pod, name); err != nil {
+		return err
+	}
+	pod, c, err := b.getManagedContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	meta := containerMetadata(pod, c.Name)
+	ns, podName, instance := ref.Namespace, ref.Pod, c.Instance
+	if status := containerStatus(pod, instance); status == nil || status.State.Running == nil {
+		// Like docker, stopping a stopped container is fine. If its restart
+		// policy is about to restart it, that's canceled.
+		if shouldRestart(meta, status) {
+			return b.markStopped(ctx, ref, meta)
+		}
+		return nil
+	}
+	if err := b.markStopped(ctx, ref, meta); err != nil {
+		return err
+	}
+
+	signal := options.Signal
+	if signal == "" {
+		signal = meta.StopSignal
+	}
+	sig, err := parseSignal(signal, syscall.SIGTERM)
+	if err != nil {
+		return err
+	}
+	timeout := defaultStopTimeout
+	if options.Timeout != nil {
+		timeout = *options.Timeout
+	} else if meta.StopTimeout != nil {
+		timeout = *meta.StopTimeout
+	}
+
+	if sig != syscall.SIGKILL {
+		b.events.logContainerRef(ref, events.ActionKill, map[string]string{
+			"signal": strconv.Itoa(int(sig)),
+		})
+		if err := b.signalContainer(ctx, ns, podName, instance, sig); err != nil {
+			return err
+		}
+		// A paused container is resumed so it can act on the signal.
+		if isPaused(pod, c) {
+			b.events.logContainerRef(ref, events.ActionUnPause, nil)
+			if err := b.thaw(ctx, ref, instance); err != nil {
+				return err
+			}
+		}
+		_, err := b.waitForExit(ctx, ns, podName, instance, time.Duration(timeout)*time.Second)
+		if err == nil {
+			b.events.logContainerRef(ref, events.ActionStop, nil)
+			return nil
+		}
+		if !errdefs.IsDeadline(err) {
+			return err
+		}
+		fmt.Printf("container %s did not exit within %ds of signal %d, killing it\n", ref.Name, timeout, sig)
+	}
+
+	b.events.logContainerRef(ref, events.ActionKill, map[string]string{
+		"signal": strconv.Itoa(int(syscall.SIGKILL)),
+	})
+	if err := b.kill(ctx, ns, podName, instance); err != nil {
+		return err
+	}
+	b.events.logContainerRef(ref, events.ActionStop, nil)
+	return nil
 }
 
 func (b *Backend) ContainerUnpause(name string) error {
-	return ErrUnimplemented
+	ctx := context.TODO()
+
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return err
+	}
+	pod, c, err := b.getManagedContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	if !isPaused(pod, c) {
+		return errdefs.Conflict(fmt.Errorf("Container %s is not paused", ref.ID()))
+	}
+
+	if err := b.thaw(ctx, ref, c.Instance); err != nil {
+		return err
+	}
+	b.events.logContainerRef(ref, events.ActionUnPause, nil)
+	return nil
 }
 
 func (b *Backend) ContainerUpdate(name string, hostConfig *container.HostConfig) (container.ContainerUpdateOKBody, error) {
@@ -105,29 +439,61 @@ func (b *Backend) ContainerUpdate(name string, hostConfig *container.HostConfig)
 }
 
 func (b *Backend) ContainerWait(ctx context.Context, name string, condition containerpkg.WaitCondition) (<-chan containerpkg.StateStatus, error) {
-	s := strings.Split(name, ".")
-	if len(s) != 3 {
-		return nil, fmt.Errorf("invalid container name %q", name)
+	ref, err := parseContainerRef(name)
+	if err != nil {
+		return nil, err
+	}
+	ns, pod, container := ref.Namespace, ref.Pod, ref.Name
+	if err := checkAccess(ctx, ns, pod, name); err != nil {
+		return nil, err
+	}
+
+	_, c, err := b.getEphemeralContainer(ctx, ns, pod, container)
+	if err != nil {
+		return nil, err
 	}
-	ns, pod, container := s[0], s[1], s[2]
 
 	state := containerpkg.NewState()
+	// Ephemeral containers start as soon as they're created, so they're
+	// considered running until they terminate.
+	state.SetRunning(nil, nil, true)
+	waitC := state.Wait(ctx, condition)
 
 	go func() {
-		s, err := b.waitForReady(ctx, ns, pod, container)
-		if err != nil {
+		status, err := b.waitForContainer(ctx, ns, pod, c.Instance, func(s *corev1.ContainerStatus) bool {
+			return s.State.Terminated != nil
+		})
+		if ctx.Err() != nil {
+			// The waiter has already been told about the cancellation.
 			return
 		}
-		if s.State.Terminated != nil {
-			state.SetStopped(&containerpkg.ExitStatus{
-				ExitCode: int(s.State.Terminated.ExitCode),
-				ExitedAt: s.State.Terminated.FinishedAt.Time,
-			})
+
+		exit := &containerpkg.ExitStatus{ExitCode: 125}
+		if err == nil {
+			exit = &containerpkg.ExitStatus{
+				ExitCode: int(status.State.Terminated.ExitCode),
+				ExitedAt: status.State.Terminated.FinishedAt.Time,
+			}
 		}
-		if s.State.Running != nil {
-			state.SetRunning(nil, nil, true)
+		state.Lock()
+		state.SetError(err)
+		state.SetStopped(exit)
+		state.Unlock()
+		if err != nil || condition != containerpkg.WaitConditionRemoved {
+			state.SetRemovalError(err)
+			return
+		}
+
+		// Containers are removed by docker rm, or by the supervisor once they
+		// exit if they were created with --rm.
+		_, err = b.pods.WaitFor(ctx, ns, pod, func(p *corev1.Pod) (bool, error) {
+			return p == nil || containerMetadata(p, c.Name).Removed, nil
+		})
+		if ctx.Err() != nil {
+			return
 		}
+		state.SetRemovalError(err)
 	}()
 
-	return state.Wait(ctx, condition), nil
+	return waitC, nil
 }
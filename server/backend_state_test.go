@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerCreateName(t *testing.T) {
+	pod := testPod("ns", "pod", "existing")
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "cgr.dev/chainguard/bash"}}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+
+	create := func(name string) (container.CreateResponse, error) {
+		return b.ContainerCreate(ctx, backend.ContainerCreateConfig{
+			Name:   name,
+			Config: &container.Config{Image: "cgr.dev/chainguard/bash"},
+		})
+	}
+
+	resp, err := create("/db")
+	if err != nil {
+		t.Fatalf("ContainerCreate(db) = %v", err)
+	}
+	if want := (containerRef{Namespace: "ns", Pod: "pod", Name: "db"}).ID(); resp.ID != want {
+		t.Errorf("ContainerCreate(db) ID = %s, want %s", resp.ID, want)
+	}
+
+	for _, name := range []string{"db", "existing", "main"} {
+		if _, err := create(name); !errdefs.IsConflict(err) {
+			t.Errorf("ContainerCreate(%s) = %v, want conflict error", name, err)
+		}
+	}
+	for _, name := range []string{"-leading-dash", "has space", "x"} {
+		if _, err := create(name); !errdefs.IsInvalidParameter(err) {
+			t.Errorf("ContainerCreate(%s) = %v, want invalid parameter error", name, err)
+		}
+	}
+
+	// Docker names Kubernetes doesn't accept get a generated container name,
+	// and are the same as any other docker name otherwise.
+	for _, name := range []string{"Has_Underscore", "UPPER", "dots.in.name"} {
+		resp, err := create(name)
+		if err != nil {
+			t.Fatalf("ContainerCreate(%s) = %v", name, err)
+		}
+		pod, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, ok, err := matchContainer(pod, name)
+		if err != nil || !ok || ref.ID() != resp.ID {
+			t.Errorf("matchContainer(%s) = %v, %t, %v, want %s", name, ref, ok, err, resp.ID)
+		}
+		if !isContainerName(ref.Name) {
+			t.Errorf("ContainerCreate(%s) created container %s, want a valid Kubernetes name", name, ref.Name)
+		}
+		if _, err := create(name); !errdefs.IsConflict(err) {
+			t.Errorf("ContainerCreate(%s) again = %v, want conflict error", name, err)
+		}
+	}
+
+	// Generated names never conflict.
+	if _, err := create(""); err != nil {
+		t.Errorf("ContainerCreate() = %v", err)
+	}
+}
+
+func TestContainerCreateLabels(t *testing.T) {
+	b := newTestBackend(t, testPod("ns", "pod"))
+	ctx := callerContext("ns", "pod")
+
+	labels := map[string]string{"com.example.app": "db"}
+	resp, err := b.ContainerCreate(ctx, backend.ContainerCreateConfig{
+		Name: "db",
+		Config: &container.Config{
+			Image:      "cgr.dev/chainguard/bash",
+			Labels:     labels,
+			StopSignal: "SIGINT",
+		},
+	})
+	if err != nil {
+		t.Fatalf("ContainerCreate() = %v", err)
+	}
+	if len(resp.Warnings) > 0 {
+		t.Errorf("ContainerCreate() warnings = %v", resp.Warnings)
+	}
+
+	list, err := b.Containers(ctx, &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("Containers() = %d containers, want 1", len(list))
+	}
+	want := map[string]string{"com.example.app": "db", managedLabel: "true"}
+	if !reflect.DeepEqual(list[0].Labels, want) {
+		t.Errorf("Containers() labels = %v, want %v", list[0].Labels, want)
+	}
+}
+
+func TestContainerCreateConsoleSize(t *testing.T) {
+	b := newTestBackend(t, testPod("ns", "pod"))
+	ctx := callerContext("ns", "pod")
+
+	for _, name := range []string{"tty", "no-size"} {
+		hc := &container.HostConfig{}
+		if name == "tty" {
+			hc.ConsoleSize = [2]uint{24, 80}
+		}
+		if _, err := b.ContainerCreate(ctx, backend.ContainerCreateConfig{
+			Name:       name,
+			Config:     &container.Config{Image: "cgr.dev/chainguard/bash", Tty: true},
+			HostConfig: hc,
+		}); err != nil {
+			t.Fatalf("ContainerCreate(%s) = %v", name, err)
+		}
+	}
+
+	pod, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := containerMetadata(pod, "tty").ConsoleSize, (&[2]uint{24, 80}); !reflect.DeepEqual(got, want) {
+		t.Errorf("console size = %v, want %v", got, want)
+	}
+	if got := containerMetadata(pod, "no-size").ConsoleSize; got != nil {
+		t.Errorf("console size without one = %v, want none", got)
+	}
+}
@@ -4,9 +4,70 @@ import (
 	"context"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func (b *Backend) ContainersPrune(ctx context.Context, pruneFilters filters.Args) (*types.ContainersPruneReport, error) {
-	return nil, ErrUnimplemented
+	if err := pruneFilters.Validate(acceptedPruneFilters); err != nil {
+		return nil, err
+	}
+	until, err := pruneUntil(pruneFilters)
+	if err != nil {
+		return nil, err
+	}
+	ns, podName, err := getPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.ContainersPruneReport{}
+	var pruned []podContainer
+	for _, c := range podContainers(pod) {
+		if !c.Ephemeral {
+			continue
+		}
+		// Unlike docker, containers that haven't started yet are left alone,
+		// since ephemeral containers start on their own.
+		status := containerStatus(pod, c.Instance)
+		if status == nil || status.State.Terminated == nil || shouldRestart(containerMetadata(pod, c.Name), status) {
+			continue
+		}
+		if !until.IsZero() && containerCreated(pod, c).After(until) {
+			continue
+		}
+		if !matchPruneLabels(pruneFilters, containerLabels(pod, c)) {
+			continue
+		}
+		pruned = append(pruned, c)
+	}
+
+	if len(pruned) > 0 {
+		names := make([]string, 0, len(pruned))
+		for _, c := range pruned {
+			names = append(names, c.Name)
+		}
+		if err := b.removeContainers(ctx, ns, podName, names); err != nil {
+			return nil, err
+		}
+		for _, c := range pruned {
+			report.ContainersDeleted = append(report.ContainersDeleted, containerRef{Namespace: ns, Pod: podName, Name: c.Name}.ID())
+			b.events.logContainer(pod, c, events.ActionDestroy, nil)
+		}
+	}
+
+	// Ephemeral containers stay in the pod, so nothing is reclaimed until the
+	// pod is deleted.
+	b.events.Log(events.ActionPrune, events.ContainerEventType, events.Actor{
+		Attributes: map[string]string{
+			"reclaimed":  "0",
+			podAttribute: podRef(pod),
+		},
+	})
+	return report, nil
 }
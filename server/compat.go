@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// compatLevel describes how an option maps onto an ephemeral container.
+type compatLevel int
+
+const (
+	// compatIgnored options are dropped with a warning.
+	compatIgnored compatLevel = iota
+	// compatSupported options are translated (or have no observable effect).
+	compatSupported
+	// compatUnsupported options change the container's behavior if dropped,
+	// so they are rejected in strict mode.
+	compatUnsupported
+)
+
+type compatRule struct {
+	level compatLevel
+	// flag is the docker run flag used in messages.
+	flag string
+}
+
+func supported() compatRule              { return compatRule{level: compatSupported} }
+func ignored(flag string) compatRule     { return compatRule{level: compatIgnored, flag: flag} }
+func unsupported(flag string) compatRule { return compatRule{level: compatUnsupported, flag: flag} }
+
+// compatRules classifies container.Config, HostConfig and NetworkingConfig
+// fields, keyed by their path in backend.ContainerCreateConfig. Fields of
+// embedded structs (HostConfig.Resources) are keyed as if they were promoted.
+var compatRules = map[string]compatRule{
+	"Config.Hostname":        ignored("--hostname"),
+	"Config.Domainname":      ignored("--domainname"),
+	"Config.User":            supported(),
+	"Config.AttachStdin":     supported(),
+	"Config.AttachStdout":    supported(),
+	"Config.AttachStderr":    supported(),
+	"Config.ExposedPorts":    ignored("--expose"),
+	"Config.Tty":             supported(),
+	"Config.OpenStdin":       supported(),
+	"Config.StdinOnce":       supported(),
+	"Config.Env":             supported(),
+	"Config.Cmd":             supported(),
+	"Config.Healthcheck":     ignored("--health-*"),
+	"Config.ArgsEscaped":     supported(),
+	"Config.Image":           supported(),
+	"Config.Volumes":         ignored("anonymous volumes"),
+	"Config.WorkingDir":      supported(),
+	"Config.Entrypoint":      supported(),
+	"Config.NetworkDisabled": unsupported("--network=none"),
+	"Config.MacAddress":      ignored("--mac-address"),
+	"Config.OnBuild":         supported(),
+	"Config.Labels":          supported(),
+	"Config.StopSignal":      supported(),
+	"Config.StopTimeout":     supported(),
+	"Config.Shell":           supported(),
+
+	"HostConfig.Binds":           supported(),
+	"HostConfig.ContainerIDFile": supported(),
+	"HostConfig.LogConfig":       ignored("--log-driver/--log-opt"),
+	"HostConfig.PortBindings":    unsupported("-p/--publish"),
+	"HostConfig.AutoRemove":      supported(),
+	"HostConfig.VolumeDriver":    ignored("--volume-driver"),
+	"HostConfig.VolumesFrom":     supported(),
+	"HostConfig.ConsoleSize":     supported(),
+	"HostConfig.Annotations":     ignored("--annotation"),
+	"HostConfig.CapAdd":          unsupported("--cap-add"),
+	"HostConfig.CapDrop":         ignored("--cap-drop"),
+	"HostConfig.CgroupnsMode":    ignored("--cgroupns"),
+	"HostConfig.DNS":             ignored("--dns"),
+	"HostConfig.DNSOptions":      ignored("--dns-option"),
+	"HostConfig.DNSSearch":       ignored("--dns-search"),
+	"HostConfig.ExtraHosts":      ignored("--add-host"),
+	"HostConfig.GroupAdd":        ignored("--group-add"),
+	"HostConfig.IpcMode":         ignored("--ipc"),
+	"HostConfig.Cgroup":          ignored("--cgroup"),
+	"HostConfig.Links":           ignored("--link"),
+	"HostConfig.OomScoreAdj":     ignored("--oom-score-adj"),
+	"HostConfig.PidMode":         ignored("--pid"),
+	"HostConfig.Privileged":      unsupported("--privileged"),
+	"HostConfig.PublishAllPorts": unsupported("-P/--publish-all"),
+	"HostConfig.ReadonlyRootfs":  ignored("--read-only"),
+	"HostConfig.SecurityOpt":     ignored("--security-opt"),
+	"HostConfig.StorageOpt":      ignored("--storage-opt"),
+	"HostConfig.Tmpfs":           unsupported("--tmpfs"),
+	"HostConfig.UTSMode":         ignored("--uts"),
+	"HostConfig.UsernsMode":      ignored("--userns"),
+	"HostConfig.ShmSize":         ignored("--shm-size"),
+	"HostConfig.Sysctls":         ignored("--sysctl"),
+	"HostConfig.Runtime":         ignored("--runtime"),
+	"HostConfig.Isolation":       ignored("--isolation"),
+	"HostConfig.Mounts":          supported(),
+	"HostConfig.MaskedPaths":     ignored("masked paths"),
+	"HostConfig.ReadonlyPaths":   ignored("read-only paths"),
+	"HostConfig.Init":            ignored("--init"),
+
+	// Resource limits are set on the pod's containers, ephemeral containers
+	// can't have any of their own.
+	"HostConfig.CPUShares":            unsupported("-c/--cpu-shares"),
+	"HostConfig.Memory":               unsupported("-m/--memory"),
+	"HostConfig.NanoCPUs":             unsupported("--cpus"),
+	"HostConfig.CgroupParent":         ignored("--cgroup-parent"),
+	"HostConfig.BlkioWeight":          unsupported("--blkio-weight"),
+	"HostConfig.BlkioWeightDevice":    unsupported("--blkio-weight-device"),
+	"HostConfig.BlkioDeviceReadBps":   unsupported("--device-read-bps"),
+	"HostConfig.BlkioDeviceWriteBps":  unsupported("--device-write-bps"),
+	"HostConfig.BlkioDeviceReadIOps":  unsupported("--device-read-iops"),
+	"HostConfig.BlkioDeviceWriteIOps": unsupported("--device-write-iops"),
+	"HostConfig.CPUPeriod":            unsupported("--cpu-period"),
+	"HostConfig.CPUQuota":             unsupported("--cpu-quota"),
+	"HostConfig.CPURealtimePeriod":    unsupported("--cpu-rt-period"),
+	"HostConfig.CPURealtimeRuntime":   unsupported("--cpu-rt-runtime"),
+	"HostConfig.CpusetCpus":           unsupported("--cpuset-cpus"),
+	"HostConfig.CpusetMems":           unsupported("--cpuset-mems"),
+	"HostConfig.Devices":              unsupported("--device"),
+	"HostConfig.DeviceCgroupRules":    unsupported("--device-cgroup-rule"),
+	"HostConfig.DeviceRequests":       unsupported("--gpus"),
+	"HostConfig.KernelMemory":         unsupported("--kernel-memory"),
+	"HostConfig.KernelMemoryTCP":      unsupported("--kernel-memory-tcp"),
+	"HostConfig.MemoryReservation":    unsupported("--memory-reservation"),
+	"HostConfig.MemorySwap":           unsupported("--memory-swap"),
+	"HostConfig.MemorySwappiness":     unsupported("--memory-swappiness"),
+	"HostConfig.OomKillDisable":       unsupported("--oom-kill-disable"),
+	"HostConfig.PidsLimit":            unsupported("--pids-limit"),
+	"HostConfig.Ulimits":              unsupported("--ulimit"),
+	"HostConfig.CPUCount":             unsupported("--cpu-count"),
+	"HostConfig.CPUPercent":           unsupported("--cpu-percent"),
+	"HostConfig.IOMaximumIOps":        unsupported("--io-maxiops"),
+	"HostConfig.IOMaximumBandwidth":   unsupported("--io-maxbandwidth"),
+
+	// Checked separately since the CLI always sends them.
+	"HostConfig.NetworkMode":           supported(),
+	"HostConfig.RestartPolicy":         supported(),
+	"NetworkingConfig.EndpointsConfig": supported(),
+}
+
+// checkCompat reports options in config that ephemeral containers can't
+// honor. Ignored options are returned as warnings. Options that change the
+// container's behavior are an error in strict mode, and a warning otherwise.
+func checkCompat(config backend.ContainerCreateConfig, strict bool) ([]string, error) {
+	var ignoredFlags, unsupportedFlags []string
+	add := func(r compatRule) {
+		switch r.level {
+		case compatIgnored:
+			ignoredFlags = append(ignoredFlags, r.flag)
+		case compatUnsupported:
+			unsupportedFlags = append(unsupportedFlags, r.flag)
+		}
+	}
+
+	walkSet(reflect.ValueOf(config.Config), "Config", func(path string) {
+		add(lookupRule(path))
+	})
+	walkSet(reflect.ValueOf(config.HostConfig), "HostConfig", func(path string) {
+		add(lookupRule(path))
+	})
+	walkSet(reflect.ValueOf(config.NetworkingConfig), "NetworkingConfig", func(path string) {
+		add(lookupRule(path))
+	})
+
+	if hc := config.HostConfig; hc != nil {
+		switch mode := hc.NetworkMode; {
+		case mode == "", mode.IsDefault(), mode.IsBridge():
+		case mode.IsNone():
+			add(unsupported("--network=none"))
+		default:
+			add(ignored("--network"))
+		}
+	}
+	if nc := config.NetworkingConfig; nc != nil {
+		for name := range nc.EndpointsConfig {
+			if mode := container.NetworkMode(name); !mode.IsDefault() && !mode.IsBridge() {
+				add(ignored("--network"))
+				break
+			}
+		}
+	}
+	if config.Platform != nil {
+		add(ignored("--platform"))
+	}
+
+	if len(unsupportedFlags) > 0 && strict {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("options not supported by ephemeral containers: %s", strings.Join(dedupe(unsupportedFlags), ", ")))
+	}
+
+	var warnings []string
+	for _, f := range dedupe(unsupportedFlags) {
+		warnings = append(warnings, fmt.Sprintf("%s is not supported by ephemeral containers and was ignored; the container will behave differently than requested", f))
+	}
+	for _, f := range dedupe(ignoredFlags) {
+		warnings = append(warnings, fmt.Sprintf("%s is not supported by ephemeral containers and was ignored", f))
+	}
+	return warnings, nil
+}
+
+// lookupRule returns the rule for a field. Fields we don't know about
+// (e.g. added in a newer API version) are ignored with a warning.
+func lookupRule(path string) compatRule {
+	if r, ok := compatRules[path]; ok {
+		return r
+	}
+	return ignored(path)
+}
+
+// walkSet calls fn with the path of every field of the struct v (or pointer
+// to one) that is set to a non-empty value.
+func walkSet(v reflect.Value, prefix string, fn func(path string)) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			walkSet(v.Field(i), prefix, fn)
+			continue
+		}
+		if isSet(v.Field(i)) {
+			fn(prefix + "." + f.Name)
+		}
+	}
+}
+
+// isSet reports whether v holds a meaningful value. Unlike IsZero, empty
+// maps and slices and pointers to zero values are considered unset, since
+// the docker CLI sends plenty of those by default.
+func isSet(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && isSet(v.Elem())
+	case reflect.Map, reflect.Slice:
+		return v.Len() > 0
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && isSet(v.Field(i)) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !v.IsZero()
+	}
+}
+
+func dedupe(s []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
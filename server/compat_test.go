@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// cliConfig returns the config the docker CLI sends for a plain docker run.
+func cliConfig() backend.ContainerCreateConfig {
+	oomKillDisable := false
+	return backend.ContainerCreateConfig{
+		Config: &container.Config{
+			Image:        "cgr.dev/chainguard/bash",
+			Cmd:          []string{"echo", "hi"},
+			AttachStdout: true,
+			AttachStderr: true,
+			Env:          []string{},
+			Labels:       map[string]string{},
+			Volumes:      map[string]struct{}{},
+		},
+		HostConfig: &container.HostConfig{
+			NetworkMode:   "default",
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled},
+			LogConfig:     container.LogConfig{Config: map[string]string{}},
+			AutoRemove:    true,
+			ConsoleSize:   [2]uint{24, 80},
+			Resources: container.Resources{
+				OomKillDisable: &oomKillDisable,
+			},
+		},
+		NetworkingConfig: &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{},
+		},
+	}
+}
+
+func TestCheckCompatDefaults(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		warnings, err := checkCompat(cliConfig(), strict)
+		if err != nil {
+			t.Fatalf("checkCompat(strict=%t) = %v", strict, err)
+		}
+		if len(warnings) > 0 {
+			t.Errorf("checkCompat(strict=%t) warnings = %v, want none", strict, warnings)
+		}
+	}
+}
+
+func TestCheckCompat(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		modify     func(*backend.ContainerCreateConfig)
+		flag       string
+		changesRun bool
+	}{
+		{
+			desc:       "memory",
+			modify:     func(c *backend.ContainerCreateConfig) { c.HostConfig.Memory = 1 << 30 },
+			flag:       "--memory",
+			changesRun: true,
+		},
+		{
+			desc:       "privileged",
+			modify:     func(c *backend.ContainerCreateConfig) { c.HostConfig.Privileged = true },
+			flag:       "--privileged",
+			changesRun: true,
+		},
+		{
+			desc: "publish",
+			modify: func(c *backend.ContainerCreateConfig) {
+				c.HostConfig.PortBindings = nat.PortMap{"80/tcp": {{HostPort: "8080"}}}
+			},
+			flag:       "--publish",
+			changesRun: true,
+		},
+		{
+			desc: "tmpfs",
+			modify: func(c *backend.ContainerCreateConfig) {
+				c.HostConfig.Tmpfs = map[string]string{"/tmp": ""}
+			},
+			flag:       "--tmpfs",
+			changesRun: true,
+		},
+		{
+			desc:   "hostname",
+			modify: func(c *backend.ContainerCreateConfig) { c.Config.Hostname = "db" },
+			flag:   "--hostname",
+		},
+		{
+			desc: "network",
+			modify: func(c *backend.ContainerCreateConfig) {
+				c.NetworkingConfig.EndpointsConfig["mynet"] = &network.EndpointSettings{}
+			},
+			flag: "--network",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := cliConfig()
+			tc.modify(&config)
+
+			_, err := checkCompat(config, true)
+			if tc.changesRun {
+				if !errdefs.IsInvalidParameter(err) || !strings.Contains(err.Error(), tc.flag) {
+					t.Errorf("checkCompat(strict) = %v, want invalid parameter error mentioning %s", err, tc.flag)
+				}
+			} else if err != nil {
+				t.Errorf("checkCompat(strict) = %v", err)
+			}
+
+			warnings, err := checkCompat(config, false)
+			if err != nil {
+				t.Fatalf("checkCompat() = %v", err)
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tc.flag) {
+				t.Errorf("checkCompat() warnings = %v, want one mentioning %s", warnings, tc.flag)
+			}
+		})
+	}
+}
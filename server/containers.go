@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// managedLabel reports whether a container was created through levias.
+	// The pod's own containers are visible too, but aren't managed by us.
+	managedLabel = "io.levias.managed"
+)
+
+// podContainer is one of a pod's regular or ephemeral containers, as seen by
+// docker. Name is the name the container was created with, which its ID and
+// metadata are keyed by.
+type podContainer struct {
+	corev1.Container
+	// DockerName is the name docker knows the container by. It's Name
+	// unless the container was renamed, or created under a name that
+	// Kubernetes still held for another container.
+	DockerName string
+	// Instance is the name of the k8s container currently backing the
+	// container. Ephemeral containers can't be restarted, so restarting one
+	// replaces it with a new instance under a different name.
+	Instance string
+	// Ephemeral is set for containers created through levias.
+	Ephemeral bool
+	// Removed is set for containers removed through docker. Ephemeral
+	// containers can't be deleted, so they stay in the pod regardless.
+	Removed bool
+}
+
+// fieldPath returns the path k8s uses to refer to the container in events.
+func (c podContainer) fieldPath() string {
+	if c.Ephemeral {
+		return fmt.Sprintf("spec.ephemeralContainers{%s}", c.Instance)
+	}
+	return fmt.Sprintf("spec.containers{%s}", c.Instance)
+}
+
+// podContainers returns the pod's regular containers followed by its
+// ephemeral containers. Init containers are left out since they've finished
+// by the time anything could talk to us. Restarted containers are only
+// returned once, backed by their newest instance, and removed containers are
+// left out.
+func podContainers(pod *corev1.Pod) []podContainer {
+	all := allPodContainers(pod)
+	out := make([]podContainer, 0, len(all))
+	for _, c := range all {
+		if !c.Removed {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// allPodContainers is like podContainers, but includes removed containers.
+func allPodContainers(pod *corev1.Pod) []podContainer {
+	// Map every instance of a restarted container to its container name, and
+	// hide the ones that have been replaced.
+	names := map[string]string{}
+	replaced := map[string]bool{}
+	removed := map[string]bool{}
+	for name, meta := range podMetadata(pod) {
+		if meta == nil {
+			continue
+		}
+		removed[name] = meta.Removed
+		if len(meta.Instances) == 0 {
+			continue
+		}
+		current := currentInstance(pod, name, meta)
+		for _, instance := range append([]string{name}, meta.Instances...) {
+			if instance != current {
+				replaced[instance] = true
+			}
+		}
+		names[current] = name
+	}
+
+	aliases := podNames(pod)
+	out := make([]podContainer, 0, len(pod.Spec.Containers)+len(pod.Spec.EphemeralContainers))
+	for _, c := range pod.Spec.Containers {
+		out = append(out, podContainer{Container: c, DockerName: c.Name, Instance: c.Name})
+	}
+	for _, ec := range pod.Spec.EphemeralContainers {
+		if replaced[ec.Name] {
+			continue
+		}
+		c := corev1.Container(ec.EphemeralContainerCommon)
+		if name, ok := names[ec.Name]; ok {
+			c.Name = name
+		}
+		out = append(out, podContainer{
+			Container:  c,
+			DockerName: dockerName(aliases, c.Name),
+			Instance:   ec.Name,
+			Ephemeral:  true,
+			Removed:    removed[c.Name],
+		})
+	}
+	return out
+}
+
+// currentInstance returns the newest instance of the named container that
+// has made it into the pod spec.
+func currentInstance(pod *corev1.Pod, name string, meta *containerMeta) string {
+	for i := len(meta.Instances) - 1; i >= 0; i-- {
+		if findEphemeralContainer(pod, meta.Instances[i]) != nil {
+			return meta.Instances[i]
+		}
+	}
+	return name
+}
+
+// findContainer returns the pod's regular or ephemeral container with the
+// given name.
+func findContainer(pod *corev1.Pod, name string) (podContainer, bool) {
+	for _, c := range podContainers(pod) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return podContainer{}, false
+}
+
+// findInstance returns the container currently backed by the k8s container
+// with the given name.
+func findInstance(pod *corev1.Pod, instance string) (podContainer, bool) {
+	for _, c := range podContainers(pod) {
+		if c.Instance == instance {
+			return c, true
+		}
+	}
+	return podContainer{}, false
+}
+
+// containerStatus returns a copy of the named container's status, or nil if
+// it has none yet.
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.Name == name {
+			return c.DeepCopy()
+		}
+	}
+	return ephemeralContainerStatus(pod, name)
+}
+
+// getContainer returns the pod and the spec of the named container.
+func (b *Backend) getContainer(ctx context.Context, ns, podName, name string) (*corev1.Pod, podContainer, error) {
+	pod, err := b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, podContainer{}, err
+	}
+	c, ok := findContainer(pod, name)
+	if !ok {
+		return nil, podContainer{}, errdefs.NotFound(fmt.Errorf("container %s not found", name))
+	}
+	return pod, c, nil
+}
+
+// containerLabels returns the docker labels of the container.
+func containerLabels(pod *corev1.Pod, c podContainer) map[string]string {
+	labels := map[string]string{}
+	if c.Ephemeral {
+		for k, v := range containerMetadata(pod, c.Name).Labels {
+			labels[k] = v
+		}
+	}
+	labels[managedLabel] = strconv.FormatBool(c.Ephemeral)
+	return labels
+}
+
+// containerCreated returns when the container was created. The pod's own
+// containers are as old as the pod.
+func containerCreated(pod *corev1.Pod, c podContainer) time.Time {
+	if c.Ephemeral {
+		if created := containerMetadata(pod, c.Name).Created; !created.IsZero() {
+			return created
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// getManagedContainer is like getContainer, but only allows containers
+// created through levias. The pod's own containers belong to Kubernetes and
+// can't be changed through docker.
+func (b *Backend) getManagedContainer(ctx context.Context, ns, podName, name string) (*corev1.Pod, podContainer, error) {
+	pod, c, err := b.getContainer(ctx, ns, podName, name)
+	if err != nil {
+		return nil, podContainer{}, err
+	}
+	if !c.Ephemeral {
+		return nil, podContainer{}, errUnmanaged(name, podName)
+	}
+	return pod, c, nil
+}
+
+// errUnmanaged is the error returned for changes to the pod's own containers.
+func errUnmanaged(name, podName string) error {
+	return errdefs.Forbidden(fmt.Errorf("container %s is part of pod %s and can't be changed through docker", name, podName))
+}
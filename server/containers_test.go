@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegularContainers(t *testing.T) {
+	pod := testPod("ns", "pod", "levias-abc")
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "cgr.dev/chainguard/go", Args: []string{"go", "test"}}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:    "main",
+		ImageID: "cgr.dev/chainguard/go@sha256:abc",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	main := containerRef{Namespace: "ns", Pod: "pod", Name: "main"}
+
+	ref, err := b.resolveContainer(ctx, "main")
+	if err != nil {
+		t.Fatalf("resolveContainer(main) = %v", err)
+	}
+	if ref != main {
+		t.Errorf("resolveContainer(main) = %v, want %v", ref, main)
+	}
+
+	list, err := b.Containers(ctx, &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	managed := map[string]string{}
+	for _, c := range list {
+		managed[strings.TrimPrefix(c.Names[0], "/")] = c.Labels[managedLabel]
+	}
+	if want := map[string]string{"main": "false", "levias-abc": "true"}; !reflect.DeepEqual(managed, want) {
+		t.Errorf("Containers() managed labels = %v, want %v", managed, want)
+	}
+
+	got, err := b.ContainerInspect(ctx, main.String(), false, "1.45")
+	if err != nil {
+		t.Fatalf("ContainerInspect(main) = %v", err)
+	}
+	inspect := got.(types.ContainerJSON)
+	if inspect.ID != main.ID() || inspect.Name != "/main" {
+		t.Errorf("ContainerInspect(main) = %s %s, want %s /main", inspect.ID, inspect.Name, main.ID())
+	}
+	if !inspect.State.Running || inspect.State.Status != "running" {
+		t.Errorf("ContainerInspect(main) state = %+v, want running", inspect.State)
+	}
+	if inspect.Path != "go" || len(inspect.Args) != 1 || inspect.Args[0] != "test" {
+		t.Errorf("ContainerInspect(main) command = %s %v, want go [test]", inspect.Path, inspect.Args)
+	}
+
+	if _, err := b.ContainerInspect(callerContext("ns", "otherpod"), main.String(), false, "1.45"); err == nil {
+		t.Error("ContainerInspect() from another pod succeeded")
+	}
+	if _, _, err := b.ContainerLogs(context.Background(), main.String(), &container.LogsOptions{}); err == nil {
+		t.Error("ContainerLogs() without claims succeeded")
+	}
+}
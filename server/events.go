@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	daemonevents "github.com/docker/docker/daemon/events"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// podAttribute is added to every event so subscriptions can be scoped to
+	// the caller's pod.
+	podAttribute = "io.levias.pod"
+)
+
+// eventBus turns pod changes and exec activity into docker events.
+type eventBus struct {
+	*daemonevents.Events
+	pods *podWatcher
+}
+
+func newEventBus(pods *podWatcher) *eventBus {
+	e := &eventBus{
+		Events: daemonevents.New(),
+		pods:   pods,
+	}
+	// Pods that already exist when the informer starts are ignored - we only
+	// report changes from here on out.
+	pods.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			e.podUpdated(oldPod, newPod)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				e.podDeleted(pod)
+			}
+		},
+	})
+	return e
+}
+
+func (e *eventBus) podUpdated(oldPod, newPod *corev1.Pod) {
+	for _, c := range podContainers(newPod) {
+		if !c.Ephemeral {
+			continue
+		}
+		// Restarting a container replaces its ephemeral container, which
+		// docker reports as the same container starting again.
+		if c.Instance == c.Name && findEphemeralContainer(oldPod, c.Instance) == nil {
+			e.logContainer(newPod, c, events.ActionCreate, nil)
+		}
+
+		status := ephemeralContainerStatus(newPod, c.Instance)
+		if status == nil {
+			continue
+		}
+		oldStatus := ephemeralContainerStatus(oldPod, c.Instance)
+		if oldStatus == nil {
+			oldStatus = &corev1.ContainerStatus{}
+		}
+
+		if status.ImageID != "" && oldStatus.ImageID == "" {
+			e.Log(events.ActionPull, events.ImageEventType, events.Actor{
+				ID: c.Image,
+				Attributes: map[string]string{
+					"name":       c.Image,
+					podAttribute: podRef(newPod),
+				},
+			})
+		}
+
+		started := status.State.Running != nil || status.State.Terminated != nil
+		wasStarted := oldStatus.State.Running != nil || oldStatus.State.Terminated != nil
+		if started && !wasStarted {
+			e.logContainer(newPod, c, events.ActionStart, nil)
+		}
+		if t := status.State.Terminated; t != nil && oldStatus.State.Terminated == nil {
+			e.logContainer(newPod, c, events.ActionDie, map[string]string{
+				"exitCode": strconv.Itoa(int(t.ExitCode)),
+			})
+		}
+	}
+}
+
+func (e *eventBus) podDeleted(pod *corev1.Pod) {
+	for _, c := range podContainers(pod) {
+		if !c.Ephemeral {
+			continue
+		}
+		if status := ephemeralContainerStatus(pod, c.Instance); status != nil && status.State.Running != nil {
+			e.logContainer(pod, c, events.ActionDie, map[string]string{
+				"exitCode": "137",
+			})
+		}
+		e.logContainer(pod, c, events.ActionDestroy, nil)
+	}
+}
+
+// logExec logs an exec lifecycle event against the container the exec runs in.
+func (e *eventBus) logExec(ref containerRef, execID string, cmd []string, action events.Action, attributes map[string]string) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attributes["execID"] = execID
+	attributes["name"] = ref.Name
+	attributes[podAttribute] = ref.Namespace + "/" + ref.Pod
+	if p, err := e.pods.Get(ref.Namespace, ref.Pod); err == nil && p != nil {
+		if c, ok := findContainer(p, ref.Name); ok {
+			attributes["name"] = c.DockerName
+			attributes["image"] = c.Image
+		}
+	}
+	// Docker includes the command in exec create/start actions.
+	if action != events.ActionExecDie {
+		action = events.Action(string(action) + ": " + strings.Join(cmd, " "))
+	}
+	e.Log(action, events.ContainerEventType, events.Actor{
+		ID:         ref.ID(),
+		Attributes: attributes,
+	})
+}
+
+// logContainerRef logs an event for a levias container, looking it up in the
+// informer's cache.
+func (e *eventBus) logContainerRef(ref containerRef, action events.Action, attributes map[string]string) {
+	p, err := e.pods.Get(ref.Namespace, ref.Pod)
+	if err != nil || p == nil {
+		return
+	}
+	if c, ok := findContainer(p, ref.Name); ok && c.Ephemeral {
+		e.logContainer(p, c, action, attributes)
+	}
+}
+
+func (e *eventBus) logContainer(pod *corev1.Pod, c podContainer, action events.Action, attributes map[string]string) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	// Like docker, container labels are included as attributes.
+	for k, v := range containerMetadata(pod, c.Name).Labels {
+		if _, ok := attributes[k]; !ok {
+			attributes[k] = v
+		}
+	}
+	attributes["name"] = c.DockerName
+	attributes["image"] = c.Image
+	attributes[podAttribute] = podRef(pod)
+	ref := containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: c.Name}
+	e.Log(action, events.ContainerEventType, events.Actor{
+		ID:         ref.ID(),
+		Attributes: attributes,
+	})
+}
+
+// scopeEventFilters restricts the filters in an events query to the given pod.
+func scopeEventFilters(query url.Values, ns, pod string) error {
+	ef, err := filters.FromJSON(query.Get("filters"))
+	if err != nil {
+		return err
+	}
+	ef.Add("label", podAttribute+"="+ns+"/"+pod)
+	raw, err := filters.ToJSON(ef)
+	if err != nil {
+		return err
+	}
+	query.Set("filters", raw)
+	return nil
+}
+
+func podRef(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func findEphemeralContainer(pod *corev1.Pod, name string) *corev1.EphemeralContainer {
+	for i := range pod.Spec.EphemeralContainers {
+		if pod.Spec.EphemeralContainers[i].Name == name {
+			return &pod.Spec.EphemeralContainers[i]
+		}
+	}
+	return nil
+}
+
+func (b *Backend) SubscribeToEvents(since, until time.Time, ef filters.Args) ([]events.Message, chan interface{}) {
+	return b.events.SubscribeTopic(since, until, daemonevents.NewFilter(ef))
+}
+
+func (b *Backend) UnsubscribeFromEvents(l chan interface{}) {
+	b.events.Evict(l)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/filters"
+	daemonevents "github.com/docker/docker/daemon/events"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// loggedEvents returns the "type action" of every event logged so far.
+func loggedEvents(e *eventBus) []string {
+	msgs, _, cancel := e.Subscribe()
+	defer cancel()
+	var out []string
+	for _, m := range msgs {
+		out = append(out, string(m.Type)+" "+string(m.Action))
+	}
+	return out
+}
+
+func TestPodUpdatedEvents(t *testing.T) {
+	waiting := corev1.ContainerStatus{
+		Name:  "web",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+	}
+	running := corev1.ContainerStatus{
+		Name:    "web",
+		ImageID: "sha256:abc",
+		State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}},
+	}
+	exited := corev1.ContainerStatus{
+		Name:    "web",
+		ImageID: "sha256:abc",
+		State:   corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2}},
+	}
+	withStatus := func(pod *corev1.Pod, statuses ...corev1.ContainerStatus) *corev1.Pod {
+		pod.Status.EphemeralContainerStatuses = statuses
+		return pod
+	}
+	restarted := func(statuses ...corev1.ContainerStatus) *corev1.Pod {
+		pod := testPod("ns", "pod", "web", "web-abcde")
+		pod.Annotations = map[string]string{metadataAnnotation: `{"web":{"instances":["web-abcde"]}}`}
+		for i := range statuses {
+			statuses[i].Name = "web-abcde"
+		}
+		return withStatus(pod, statuses...)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		oldPod *corev1.Pod
+		newPod *corev1.Pod
+		want   []string
+	}{{
+		desc:   "created",
+		oldPod: testPod("ns", "pod"),
+		newPod: testPod("ns", "pod", "web"),
+		want:   []string{"container create"},
+	}, {
+		desc:   "waiting",
+		oldPod: testPod("ns", "pod", "web"),
+		newPod: withStatus(testPod("ns", "pod", "web"), waiting),
+	}, {
+		desc:   "started",
+		oldPod: withStatus(testPod("ns", "pod", "web"), waiting),
+		newPod: withStatus(testPod("ns", "pod", "web"), running),
+		want:   []string{"image pull", "container start"},
+	}, {
+		desc:   "still running",
+		oldPod: withStatus(testPod("ns", "pod", "web"), running),
+		newPod: withStatus(testPod("ns", "pod", "web"), running),
+	}, {
+		desc:   "exited",
+		oldPod: withStatus(testPod("ns", "pod", "web"), running),
+		newPod: withStatus(testPod("ns", "pod", "web"), exited),
+		want:   []string{"container die"},
+	}, {
+		desc:   "created and exited between updates",
+		oldPod: testPod("ns", "pod"),
+		newPod: withStatus(testPod("ns", "pod", "web"), exited),
+		want:   []string{"container create", "image pull", "container start", "container die"},
+	}, {
+		desc:   "restarted",
+		oldPod: withStatus(testPod("ns", "pod", "web"), exited),
+		newPod: restarted(running),
+		want:   []string{"image pull", "container start"},
+	}, {
+		desc: "regular containers",
+		oldPod: func() *corev1.Pod {
+			pod := testPod("ns", "pod")
+			pod.Spec.Containers = []corev1.Container{{Name: "main"}}
+			return pod
+		}(),
+		newPod: func() *corev1.Pod {
+			pod := testPod("ns", "pod")
+			pod.Spec.Containers = []corev1.Container{{Name: "main"}}
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{running}
+			pod.Status.ContainerStatuses[0].Name = "main"
+			return pod
+		}(),
+	}} {
+		e := &eventBus{Events: daemonevents.New()}
+		e.podUpdated(tc.oldPod, tc.newPod)
+		if got := loggedEvents(e); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: events = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestPodDeletedEvents(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "job")
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "web",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}, {
+		Name:  "job",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}},
+	}}
+
+	e := &eventBus{Events: daemonevents.New()}
+	e.podDeleted(pod)
+	want := []string{"container die", "container destroy", "container destroy"}
+	if got := loggedEvents(e); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestScopeEventFilters(t *testing.T) {
+	query := url.Values{}
+	query.Set("filters", `{"type":{"container":true}}`)
+	if err := scopeEventFilters(query, "ns", "pod"); err != nil {
+		t.Fatalf("scopeEventFilters() = %v", err)
+	}
+	ef, err := filters.FromJSON(query.Get("filters"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ef.ExactMatch("type", "container") {
+		t.Errorf("filters = %v, want the type filter kept", query.Get("filters"))
+	}
+
+	// Events of other pods are filtered out, even if they match otherwise.
+	e := &eventBus{Events: daemonevents.New()}
+	e.podUpdated(testPod("ns", "pod"), testPod("ns", "pod", "web"))
+	e.podUpdated(testPod("ns", "other"), testPod("ns", "other", "web"))
+	e.podUpdated(testPod("otherns", "pod"), testPod("otherns", "pod", "web"))
+	msgs, _, cancel := e.Subscribe()
+	defer cancel()
+
+	filter := daemonevents.NewFilter(ef)
+	var got []string
+	for _, m := range msgs {
+		if filter.Include(m) {
+			got = append(got, m.Actor.Attributes[podAttribute])
+		}
+	}
+	if want := []string{"ns/pod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("included events from %v, want %v", got, want)
+	}
+
+	if err := scopeEventFilters(url.Values{"filters": {"{"}}, "ns", "pod"); err == nil {
+		t.Error("scopeEventFilters() with malformed filters succeeded")
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// execRecord is the persisted form of an exec session. Config only holds
+// what inspect and start need: the rest, the environment in particular, may
+// carry secrets that have no business in a ConfigMap.
+type execRecord struct {
+	ID        string            `json:"id"`
+	Container string            `json:"container"`
+	Config    *types.ExecConfig `json:"config"`
+	Running   bool              `json:"running,omitempty"`
+	ExitCode  *int              `json:"exitCode,omitempty"`
+	Updated   time.Time         `json:"updated"`
+}
+
+// ExecPersister stores exec sessions outside of the server process so they
+// survive restarts.
+type ExecPersister interface {
+	List(ctx context.Context) ([]*execRecord, error)
+	Save(ctx context.Context, rec *execRecord) error
+	Delete(ctx context.Context, id string) error
+}
+
+// ExecStore holds exec sessions, expiring them after a TTL and bounding the
+// number of sessions kept.
+type ExecStore struct {
+	mu        sync.Mutex
+	persister ExecPersister
+	ttl       time.Duration
+	maxSize   int
+	execs     map[string]*ExecState
+}
+
+func NewExecStore(persister ExecPersister, ttl time.Duration, maxSize int) *ExecStore {
+	return &ExecStore{
+		persister: persister,
+		ttl:       ttl,
+		maxSize:   maxSize,
+		execs:     map[string]*ExecState{},
+	}
+}
+
+// Restore loads previously persisted sessions into the store.
+func (s *ExecStore) Restore(ctx context.Context) error {
+	recs, err := s.persister.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, rec := range recs {
+		ref, err := parseContainerRef(rec.Container)
+		if err != nil {
+			fmt.Printf("skipping exec %s: %v\n", rec.ID, err)
+			continue
+		}
+		if rec.Config == nil {
+			fmt.Printf("skipping exec %s: no config\n", rec.ID)
+			continue
+		}
+		// Sessions are always restored as not running - any stream that was
+		// running belonged to the previous server and is gone now.
+		s.execs[rec.ID] = &ExecState{
+			id:        rec.ID,
+			container: ref,
+			cfg:       rec.Config,
+			exitCode:  rec.ExitCode,
+			updated:   rec.Updated,
+		}
+	}
+	evicted := s.evict()
+	s.mu.Unlock()
+
+	s.forget(ctx, evicted)
+	return nil
+}
+
+// Add registers a new exec session.
+func (s *ExecStore) Add(ctx context.Context, id string, container containerRef, cfg *types.ExecConfig) (*ExecState, error) {
+	e := &ExecState{
+		id:        id,
+		container: container,
+		cfg:       cfg,
+		updated:   time.Now(),
+	}
+	// The persister may have to talk to the API server, so it's only called
+	// once the store is unlocked.
+	evicted, err := s.insert(e)
+	s.forget(ctx, evicted)
+	if err != nil {
+		return nil, err
+	}
+	// Persisting only matters once the server restarts, so a session that
+	// couldn't be saved is still good for this one.
+	if err := s.persister.Save(ctx, e.record()); err != nil {
+		fmt.Printf("error saving exec %s: %v\n", id, err)
+	}
+	return e, nil
+}
+
+// insert adds e to the store, making room for it if needed. It returns the
+// IDs of the sessions evicted along the way.
+func (s *ExecStore) insert(e *ExecState) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	evicted := s.evict()
+	if len(s.execs) >= s.maxSize {
+		return evicted, errdefs.Unavailable(fmt.Errorf("too many exec sessions (max %d)", s.maxSize))
+	}
+	s.execs[e.id] = e
+	return evicted, nil
+}
+
+// Get returns the exec session with the given ID.
+func (s *ExecStore) Get(id string) (*ExecState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.execs[id]
+	if !ok || s.expired(e) {
+		return nil, errdefs.NotFound(fmt.Errorf("no such exec: %s", id))
+	}
+	return e, nil
+}
+
+// Save persists the current state of an exec session.
+func (s *ExecStore) Save(ctx context.Context, e *ExecState) error {
+	e.touch()
+	return s.persister.Save(ctx, e.record())
+}
+
+// evict drops expired sessions, then the least recently updated finished
+// sessions until the store is under its size limit. Running sessions are never
+// evicted. It returns the IDs of the dropped sessions, which still have to be
+// deleted from the persister. Must be called with s.mu held.
+func (s *ExecStore) evict() []string {
+	var evicted []string
+	var idle []*ExecState
+	for id, e := range s.execs {
+		if s.expired(e) {
+			delete(s.execs, id)
+			evicted = append(evicted, id)
+			continue
+		}
+		if running, _ := e.status(); !running {
+			idle = append(idle, e)
+		}
+	}
+
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].lastUpdated().Before(idle[j].lastUpdated())
+	})
+	for _, e := range idle {
+		if len(s.execs) < s.maxSize {
+			break
+		}
+		delete(s.execs, e.id)
+		evicted = append(evicted, e.id)
+	}
+	return evicted
+}
+
+func (s *ExecStore) expired(e *ExecState) bool {
+	running, _ := e.status()
+	return !running && time.Since(e.lastUpdated()) > s.ttl
+}
+
+// forget deletes evicted sessions from the persister. It must be called
+// without s.mu held.
+func (s *ExecStore) forget(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		if err := s.persister.Delete(ctx, id); err != nil {
+			fmt.Printf("error deleting exec %s: %v\n", id, err)
+		}
+	}
+}
+
+// memoryExecPersister keeps sessions in memory only, so they are lost on restart.
+type memoryExecPersister struct {
+	mu   sync.Mutex
+	recs map[string]*execRecord
+}
+
+func NewMemoryExecPersister() ExecPersister {
+	return &memoryExecPersister{
+		recs: map[string]*execRecord{},
+	}
+}
+
+func (p *memoryExecPersister) List(ctx context.Context) ([]*execRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]*execRecord, 0, len(p.recs))
+	for _, rec := range p.recs {
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func (p *memoryExecPersister) Save(ctx context.Context, rec *execRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recs[rec.ID] = rec
+	return nil
+}
+
+func (p *memoryExecPersister) Delete(ctx context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.recs, id)
+	return nil
+}
+
+const (
+	// execStoreLabel marks the ConfigMaps of a ConfigMap exec store, with the
+	// store's name as its value.
+	execStoreLabel = "levias.io/exec-store"
+	// execRecordKey is the ConfigMap key holding a session's record.
+	execRecordKey = "exec"
+)
+
+// configMapExecPersister stores each session in a ConfigMap of its own, named
+// after the store and the session. Sessions are written on every create, start
+// and exit, so sharing an object between them would have concurrent execs
+// conflict with each other and run into the object size limit.
+type configMapExecPersister struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func NewConfigMapExecPersister(client kubernetes.Interface, namespace, name string) ExecPersister {
+	return &configMapExecPersister{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// objectName returns the name of the session's ConfigMap.
+func (p *configMapExecPersister) objectName(id string) string {
+	return p.name + "-" + id
+}
+
+func (p *configMapExecPersister) List(ctx context.Context) ([]*execRecord, error) {
+	cms, err := p.client.CoreV1().ConfigMaps(p.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{execStoreLabel: p.name}.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*execRecord, 0, len(cms.Items))
+	for _, cm := range cms.Items {
+		rec := new(execRecord)
+		if err := json.Unmarshal([]byte(cm.Data[execRecordKey]), rec); err != nil {
+			fmt.Printf("skipping malformed exec %s: %v\n", cm.Name, err)
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func (p *configMapExecPersister) Save(ctx context.Context, rec *execRecord) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.objectName(rec.ID),
+			Namespace: p.namespace,
+			Labels:    map[string]string{execStoreLabel: p.name},
+		},
+		Data: map[string]string{execRecordKey: string(raw)},
+	}
+
+	// Only this server writes a session's ConfigMap, so it's replaced
+	// outright instead of being read and updated.
+	configMaps := p.client.CoreV1().ConfigMaps(p.namespace)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		}
+	}
+	return err
+}
+
+func (p *configMapExecPersister) Delete(ctx context.Context, id string) error {
+	err := p.client.CoreV1().ConfigMaps(p.namespace).Delete(ctx, p.objectName(id), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// persistedIDs returns the IDs of the sessions in p, sorted.
+func persistedIDs(t *testing.T, p ExecPersister) []string {
+	t.Helper()
+	recs, err := p.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, rec := range recs {
+		ids = append(ids, rec.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// addExec adds a finished exec that was last updated age ago.
+func addExec(t *testing.T, s *ExecStore, id string, age time.Duration) *ExecState {
+	t.Helper()
+	ref := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	e, err := s.Add(context.Background(), id, ref, &types.ExecConfig{Cmd: []string{"ls"}})
+	if err != nil {
+		t.Fatalf("Add(%s) = %v", id, err)
+	}
+	e.updated = time.Now().Add(-age)
+	return e
+}
+
+func TestExecStoreExpiry(t *testing.T) {
+	p := NewMemoryExecPersister()
+	s := NewExecStore(p, time.Hour, 10)
+
+	addExec(t, s, "old", 2*time.Hour)
+	addExec(t, s, "new", time.Minute)
+	// Running sessions never expire.
+	addExec(t, s, "running", 2*time.Hour).start()
+
+	if _, err := s.Get("old"); !errdefs.IsNotFound(err) {
+		t.Errorf("Get(old) = %v, want not found", err)
+	}
+	for _, id := range []string{"new", "running"} {
+		if _, err := s.Get(id); err != nil {
+			t.Errorf("Get(%s) = %v", id, err)
+		}
+	}
+
+	// Expired sessions are dropped from the persister the next time the
+	// store makes room.
+	addExec(t, s, "another", 0)
+	if got, want := persistedIDs(t, p), []string{"another", "new", "running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("persisted sessions = %v, want %v", got, want)
+	}
+}
+
+func TestExecStoreEviction(t *testing.T) {
+	p := NewMemoryExecPersister()
+	s := NewExecStore(p, time.Hour, 3)
+
+	addExec(t, s, "running", 30*time.Minute).start()
+	addExec(t, s, "older", 20*time.Minute)
+	addExec(t, s, "newer", 10*time.Minute)
+
+	// The least recently updated finished session makes room, even though
+	// the running one is older.
+	addExec(t, s, "a", 0)
+	if got, want := persistedIDs(t, p), []string{"a", "newer", "running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("persisted sessions = %v, want %v", got, want)
+	}
+	if _, err := s.Get("older"); !errdefs.IsNotFound(err) {
+		t.Errorf("Get(older) = %v, want not found", err)
+	}
+
+	// Once every session is running, there's no room left.
+	s.execs["newer"].start()
+	s.execs["a"].start()
+	ref := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	if _, err := s.Add(context.Background(), "b", ref, &types.ExecConfig{}); !errdefs.IsUnavailable(err) {
+		t.Errorf("Add() with only running sessions = %v, want unavailable", err)
+	}
+	if got, want := persistedIDs(t, p), []string{"a", "newer", "running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("persisted sessions = %v, want %v", got, want)
+	}
+}
+
+func TestExecStoreRestore(t *testing.T) {
+	ctx := context.Background()
+	ref := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	code := 3
+	p := NewMemoryExecPersister()
+	for _, rec := range []*execRecord{
+		{ID: "finished", Container: ref.String(), Config: &types.ExecConfig{Cmd: []string{"ls"}}, ExitCode: &code, Updated: time.Now()},
+		{ID: "running", Container: ref.String(), Config: &types.ExecConfig{}, Running: true, Updated: time.Now()},
+		{ID: "expired", Container: ref.String(), Config: &types.ExecConfig{}, Updated: time.Now().Add(-2 * time.Hour)},
+		{ID: "bad-container", Container: "web", Config: &types.ExecConfig{}, Updated: time.Now()},
+		{ID: "no-config", Container: ref.String(), Updated: time.Now()},
+	} {
+		if err := p.Save(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewExecStore(p, time.Hour, 10)
+	if err := s.Restore(ctx); err != nil {
+		t.Fatalf("Restore() = %v", err)
+	}
+
+	e, err := s.Get("finished")
+	if err != nil {
+		t.Fatalf("Get(finished) = %v", err)
+	}
+	if running, exitCode := e.status(); running || exitCode == nil || *exitCode != 3 {
+		t.Errorf("status() = %t, %v, want finished with exit code 3", running, exitCode)
+	}
+	if e.container != ref || !reflect.DeepEqual(e.cfg.Cmd, []string{"ls"}) {
+		t.Errorf("restored exec = %v %v, want %v [ls]", e.container, e.cfg.Cmd, ref)
+	}
+
+	// The stream of a running session died with the previous server.
+	e, err = s.Get("running")
+	if err != nil {
+		t.Fatalf("Get(running) = %v", err)
+	}
+	if running, _ := e.status(); running {
+		t.Error("restored session is running")
+	}
+
+	for _, id := range []string{"expired", "bad-container", "no-config"} {
+		if _, err := s.Get(id); !errdefs.IsNotFound(err) {
+			t.Errorf("Get(%s) = %v, want not found", id, err)
+		}
+	}
+	for _, id := range persistedIDs(t, p) {
+		if id == "expired" {
+			t.Error("Restore() didn't delete the expired session")
+		}
+	}
+}
+
+func TestConfigMapExecPersister(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	p := NewConfigMapExecPersister(client, "levias", "execs")
+
+	// Listing before anything was saved finds nothing.
+	if got := persistedIDs(t, p); len(got) != 0 {
+		t.Errorf("List() = %v, want nothing", got)
+	}
+
+	s := NewExecStore(p, time.Hour, 10)
+	ref := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	for _, id := range []string{"a", "b"} {
+		if _, err := s.Add(ctx, id, ref, &types.ExecConfig{
+			Cmd: []string{"env"},
+			Env: []string{"TOKEN=hunter2"},
+			Tty: true,
+		}); err != nil {
+			t.Fatalf("Add(%s) = %v", id, err)
+		}
+	}
+
+	// Every session has a ConfigMap of its own.
+	cms, err := client.CoreV1().ConfigMaps("levias").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cm := range cms.Items {
+		names = append(names, cm.Name)
+		if strings.Contains(cm.Data[execRecordKey], "hunter2") {
+			t.Errorf("session %s was persisted with its environment: %s", cm.Name, cm.Data[execRecordKey])
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"execs-a", "execs-b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ConfigMaps = %v, want %v", names, want)
+	}
+
+	// Saving again replaces the session's record.
+	e, err := s.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.start()
+	e.finish(3)
+	if err := s.Save(ctx, e); err != nil {
+		t.Fatalf("Save(b) = %v", err)
+	}
+
+	if err := p.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete(a) = %v", err)
+	}
+	if err := p.Delete(ctx, "a"); err != nil {
+		t.Errorf("Delete(a) twice = %v", err)
+	}
+
+	// Malformed sessions, ones without a config and ConfigMaps of other
+	// stores are skipped.
+	updated := time.Now().Format(time.RFC3339)
+	for _, cm := range []struct{ name, store, rec string }{
+		{name: "execs-malformed", store: "execs", rec: "{"},
+		{name: "execs-no-config", store: "execs", rec: `{"id":"no-config","container":"ns/pod/web","config":null,"updated":"` + updated + `"}`},
+		{name: "other-c", store: "other", rec: `{"id":"c","container":"ns/pod/web","config":{},"updated":"` + updated + `"}`},
+	} {
+		if _, err := client.CoreV1().ConfigMaps("levias").Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cm.name, Namespace: "levias", Labels: map[string]string{execStoreLabel: cm.store}},
+			Data:       map[string]string{execRecordKey: cm.rec},
+		}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := persistedIDs(t, p), []string{"b", "no-config"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	restored := NewExecStore(p, time.Hour, 10)
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatalf("Restore() = %v", err)
+	}
+	e, err = restored.Get("b")
+	if err != nil {
+		t.Fatalf("Get(b) = %v", err)
+	}
+	want := &types.ExecConfig{Cmd: []string{"env"}, Tty: true}
+	if !reflect.DeepEqual(e.cfg, want) {
+		t.Errorf("restored config = %+v, want %+v", e.cfg, want)
+	}
+	if _, exitCode := e.status(); exitCode == nil || *exitCode != 3 {
+		t.Errorf("restored exit code = %v, want 3", exitCode)
+	}
+	for _, id := range []string{"a", "c", "no-config"} {
+		if _, err := restored.Get(id); !errdefs.IsNotFound(err) {
+			t.Errorf("Get(%s) = %v, want not found", id, err)
+		}
+	}
+}
+
+// failingPersister fails to save anything.
+type failingPersister struct {
+	ExecPersister
+}
+
+func (failingPersister) Save(context.Context, *execRecord) error {
+	return errors.New("etcd is down")
+}
+
+func TestExecStoreSaveError(t *testing.T) {
+	s := NewExecStore(failingPersister{NewMemoryExecPersister()}, time.Hour, 10)
+
+	// Sessions that couldn't be persisted still work until the server
+	// restarts.
+	addExec(t, s, "a", 0)
+	if _, err := s.Get("a"); err != nil {
+		t.Errorf("Get(a) = %v", err)
+	}
+}
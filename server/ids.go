@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// containerRef identifies a container within a pod.
+//
+// Internally containers are passed around as namespace/pod/container - "/" is
+// not allowed in any of the parts, so this can always be split unambiguously.
+// Docker clients only ever see the opaque ID returned by ID().
+type containerRef struct {
+	Namespace string
+	Pod       string
+	Name      string
+}
+
+func (r containerRef) String() string {
+	return strings.Join([]string{r.Namespace, r.Pod, r.Name}, "/")
+}
+
+// ID returns the docker-style 64 character hex ID of the container.
+func (r containerRef) ID() string {
+	sum := sha256.Sum256([]byte(r.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseContainerRef parses a reference produced by containerRef.String.
+func parseContainerRef(s string) (containerRef, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return containerRef{}, errdefs.InvalidParameter(fmt.Errorf("invalid container reference %q", s))
+	}
+	return containerRef{
+		Namespace: parts[0],
+		Pod:       parts[1],
+		Name:      parts[2],
+	}, nil
+}
+
+// resolveContainer resolves a docker container name, full ID or unique ID
+// prefix to a container in the caller's pod.
+func (b *Backend) resolveContainer(ctx context.Context, name string) (containerRef, error) {
+	ns, podName, err := getPod(ctx)
+	if err != nil {
+		return containerRef{}, errdefs.Unauthorized(err)
+	}
+
+	pod, err := b.pods.Get(ns, podName)
+	if err != nil {
+		return containerRef{}, err
+	}
+	if pod != nil {
+		if ref, ok, err := matchContainer(pod, name); ok || err != nil {
+			return ref, err
+		}
+	}
+
+	// The informer may not have caught up with a container that was just
+	// created, so check with the API server before giving up.
+	pod, err = b.client.CoreV1().Pods(ns).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return containerRef{}, err
+	}
+	if ref, ok, err := matchContainer(pod, name); ok || err != nil {
+		return ref, err
+	}
+	return containerRef{}, errdefs.NotFound(fmt.Errorf("No such container: %s", name))
+}
+
+// matchContainer finds the regular or ephemeral container in the pod matching
+// name, following docker's resolution order: full ID, then name, then unique
+// ID prefix.
+func matchContainer(pod *corev1.Pod, name string) (containerRef, bool, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return containerRef{}, false, nil
+	}
+
+	containers := podContainers(pod)
+	refs := make([]containerRef, 0, len(containers))
+	for _, c := range containers {
+		refs = append(refs, containerRef{
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			Name:      c.Name,
+		})
+	}
+
+	for _, ref := range refs {
+		if ref.ID() == name {
+			return ref, true, nil
+		}
+	}
+	for i, c := range containers {
+		if c.DockerName == name {
+			return refs[i], true, nil
+		}
+	}
+
+	var matches []containerRef
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.ID(), name) {
+			matches = append(matches, ref)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return containerRef{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	default:
+		return containerRef{}, false, errdefs.InvalidParameter(fmt.Errorf("multiple IDs found with provided prefix: %s", name))
+	}
+}
+
+// newDockerName returns the docker name to give a new container, generating one
+// if none was given.
+func newDockerName(name string) (string, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return fmt.Sprintf("levias-%s", rand.String(8)), nil
+	}
+	if err := validateDockerName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// isContainerName reports whether name can be used as is for an ephemeral
+// container.
+func isContainerName(name string) bool {
+	return len(validation.IsDNS1123Label(name)) == 0
+}
+
+// kubernetesName turns a docker name into one Kubernetes accepts as a
+// container name prefix. Docker names are already limited to
+// [a-zA-Z0-9][a-zA-Z0-9_.-]+, so only case, underscores and dots need care.
+func kubernetesName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' {
+			return '-'
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// nameInUse reports whether any container in the pod already uses name.
+// Kubernetes requires names to be unique across all of a pod's containers.
+func nameInUse(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return findEphemeralContainer(pod, name) != nil
+}
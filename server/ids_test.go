@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+)
+
+func TestContainerRef(t *testing.T) {
+	// Dots are legal in pod names and must survive a round trip.
+	ref := containerRef{Namespace: "ns", Pod: "my.pod.name", Name: "levias-abc"}
+	got, err := parseContainerRef(ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ref {
+		t.Errorf("parseContainerRef(%q) = %+v, want %+v", ref.String(), got, ref)
+	}
+	if len(ref.ID()) != 64 {
+		t.Errorf("ID() = %q, want 64 characters", ref.ID())
+	}
+
+	for _, s := range []string{"", "ns/pod", "ns/pod/", "ns/pod/c/extra", "ns.pod.c"} {
+		if _, err := parseContainerRef(s); !errdefs.IsInvalidParameter(err) {
+			t.Errorf("parseContainerRef(%q) = %v, want invalid parameter error", s, err)
+		}
+	}
+}
+
+func TestMatchContainer(t *testing.T) {
+	// Find two containers whose IDs share a first character to test ambiguous prefixes.
+	var a, b containerRef
+	seen := map[byte]containerRef{}
+	for i := 0; ; i++ {
+		ref := containerRef{Namespace: "ns", Pod: "pod", Name: fmt.Sprintf("c%d", i)}
+		if prev, ok := seen[ref.ID()[0]]; ok {
+			a, b = prev, ref
+			break
+		}
+		seen[ref.ID()[0]] = ref
+	}
+	pod := testPod("ns", "pod", a.Name, b.Name)
+
+	for _, tc := range []struct {
+		name    string
+		want    containerRef
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: a.Name, want: a, wantOK: true},
+		{name: "/" + b.Name, want: b, wantOK: true},
+		{name: a.ID(), want: a, wantOK: true},
+		{name: b.ID()[:12], want: b, wantOK: true},
+		{name: a.ID()[:1], wantErr: true},
+		{name: "missing"},
+		{name: ""},
+	} {
+		got, ok, err := matchContainer(pod, tc.name)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("matchContainer(%q) error = %v, wantErr %t", tc.name, err, tc.wantErr)
+		}
+		if ok != tc.wantOK || got != tc.want {
+			t.Errorf("matchContainer(%q) = %+v, %t, want %+v, %t", tc.name, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
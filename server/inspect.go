@@ -0,0 +1,229 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerJSON builds the docker inspect view of one of the pod's containers.
+func containerJSON(pod *corev1.Pod, c podContainer) types.ContainerJSON {
+	ref := containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: c.Name}
+	status := containerStatus(pod, c.Instance)
+	meta := &containerMeta{}
+	if c.Ephemeral {
+		meta = containerMetadata(pod, c.Name)
+	}
+
+	var env []string
+	for _, e := range c.Env {
+		// Values from secrets, configmaps etc. aren't known to us.
+		if e.ValueFrom == nil {
+			env = append(env, e.Name+"="+e.Value)
+		}
+	}
+	path, args := splitCommand(c.Command, c.Args)
+	state := containerState(status)
+	restartPolicy := container.RestartPolicy{Name: container.RestartPolicyDisabled}
+	var restarts int
+	if c.Ephemeral {
+		markRestarting(state, status, meta)
+		markPaused(state, meta)
+		if meta.RestartPolicy != nil {
+			restartPolicy = *meta.RestartPolicy
+		}
+		restarts = meta.RestartCount
+	} else if status != nil {
+		restarts = int(status.RestartCount)
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:           ref.ID(),
+			Created:      containerCreated(pod, c).Format(time.RFC3339Nano),
+			Path:         path,
+			Args:         args,
+			State:        state,
+			Image:        imageID(status),
+			Name:         "/" + c.DockerName,
+			RestartCount: restarts,
+			Driver:       "kubernetes",
+			Platform:     "linux",
+			HostConfig: &container.HostConfig{
+				NetworkMode:   "default",
+				RestartPolicy: restartPolicy,
+			},
+		},
+		Mounts: mountPoints(pod, c.VolumeMounts),
+		Config: &container.Config{
+			Hostname:   podHostname(pod),
+			User:       containerUser(c, meta),
+			Image:      c.Image,
+			Entrypoint: c.Command,
+			Cmd:        c.Args,
+			Env:        env,
+			WorkingDir: c.WorkingDir,
+			Tty:        c.TTY,
+			OpenStdin:  c.Stdin,
+			StdinOnce:  c.StdinOnce,
+			Labels:     containerLabels(pod, c),
+			StopSignal: meta.StopSignal,
+		},
+		NetworkSettings: networkSettings(pod),
+	}
+}
+
+// imageID returns the docker image ID (sha256:...) the container runs,
+// if the kubelet has resolved it.
+func imageID(status *corev1.ContainerStatus) string {
+	if status == nil || status.ImageID == "" {
+		return ""
+	}
+	// Runtimes report either a bare digest or a repo@digest reference.
+	id := status.ImageID
+	if _, digest, ok := strings.Cut(id, "@"); ok {
+		id = digest
+	}
+	return id
+}
+
+// containerUser returns the docker user spec the container runs as.
+func containerUser(c podContainer, meta *containerMeta) string {
+	if meta.User != "" {
+		return meta.User
+	}
+	sc := c.SecurityContext
+	if sc == nil || sc.RunAsUser == nil {
+		return ""
+	}
+	user := strconv.FormatInt(*sc.RunAsUser, 10)
+	if sc.RunAsGroup != nil {
+		user += ":" + strconv.FormatInt(*sc.RunAsGroup, 10)
+	}
+	return user
+}
+
+// podHostname returns the hostname shared by all of the pod's containers.
+func podHostname(pod *corev1.Pod) string {
+	if pod.Spec.Hostname != "" {
+		return pod.Spec.Hostname
+	}
+	return pod.Name
+}
+
+// mountPoints describes the container's volume mounts. Host path volumes are
+// reported as binds, everything else as volumes named after the pod volume.
+func mountPoints(pod *corev1.Pod, mounts []corev1.VolumeMount) []types.MountPoint {
+	volumes := map[string]corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+
+	out := make([]types.MountPoint, 0, len(mounts))
+	for _, vm := range mounts {
+		mp := types.MountPoint{
+			Type:        mount.TypeVolume,
+			Name:        vm.Name,
+			Destination: vm.MountPath,
+			RW:          !vm.ReadOnly,
+		}
+		if v, ok := volumes[vm.Name]; ok && v.HostPath != nil {
+			mp.Type = mount.TypeBind
+			mp.Name = ""
+			mp.Source = filepath.Join(v.HostPath.Path, vm.SubPath)
+		}
+		if vm.MountPropagation != nil {
+			switch *vm.MountPropagation {
+			case corev1.MountPropagationHostToContainer:
+				mp.Propagation = mount.PropagationRSlave
+			case corev1.MountPropagationBidirectional:
+				mp.Propagation = mount.PropagationRShared
+			default:
+				mp.Propagation = mount.PropagationRPrivate
+			}
+		}
+		out = append(out, mp)
+	}
+	return out
+}
+
+// networkSettings reports the pod's network, which all of its containers
+// share.
+func networkSettings(pod *corev1.Pod) *types.NetworkSettings {
+	ns := &types.NetworkSettings{
+		Networks: map[string]*network.EndpointSettings{},
+	}
+	if pod.Status.PodIP == "" {
+		return ns
+	}
+
+	ep := &network.EndpointSettings{
+		NetworkID: "pod",
+		IPAddress: pod.Status.PodIP,
+	}
+	ns.IPAddress = pod.Status.PodIP
+	for _, ip := range pod.Status.PodIPs {
+		if strings.Contains(ip.IP, ":") {
+			ns.GlobalIPv6Address = ip.IP
+			ep.GlobalIPv6Address = ip.IP
+		}
+	}
+	ns.Networks["pod"] = ep
+	return ns
+}
+
+// containerState converts a k8s container status into the docker equivalent.
+// A container without a status hasn't been started by the kubelet yet.
+func containerState(status *corev1.ContainerStatus) *types.ContainerState {
+	state := &types.ContainerState{
+		Status:     "created",
+		StartedAt:  time.Time{}.Format(time.RFC3339Nano),
+		FinishedAt: time.Time{}.Format(time.RFC3339Nano),
+	}
+	if status == nil {
+		return state
+	}
+
+	switch s := status.State; {
+	case s.Running != nil:
+		state.Status = "running"
+		state.Running = true
+		state.StartedAt = s.Running.StartedAt.Format(time.RFC3339Nano)
+	case s.Terminated != nil:
+		state.Status = "exited"
+		state.ExitCode = int(s.Terminated.ExitCode)
+		state.OOMKilled = s.Terminated.Reason == "OOMKilled"
+		state.StartedAt = s.Terminated.StartedAt.Format(time.RFC3339Nano)
+		state.FinishedAt = s.Terminated.FinishedAt.Format(time.RFC3339Nano)
+		if s.Terminated.ExitCode != 0 {
+			state.Error = s.Terminated.Message
+		}
+	case s.Waiting != nil:
+		if status.RestartCount > 0 {
+			state.Status = "restarting"
+			state.Restarting = true
+		}
+		if _, ok := startFailures[s.Waiting.Reason]; ok {
+			state.Error = s.Waiting.Reason + ": " + s.Waiting.Message
+		}
+	}
+	return state
+}
+
+// splitCommand returns the executable the container runs and its arguments.
+// Without a command the image's entrypoint is used, which we don't know, so
+// the args are reported as is.
+func splitCommand(command, args []string) (string, []string) {
+	full := append(append([]string{}, command...), args...)
+	if len(full) == 0 {
+		return "", nil
+	}
+	return full[0], full[1:]
+}
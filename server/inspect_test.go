@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerJSON(t *testing.T) {
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+	uid := int64(1000)
+
+	pod := testPod("ns", "pod", "db")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"db":{"created":"2024-03-01T11:59:00Z","labels":{"app":"db"},"stopSignal":"SIGINT"}}`,
+	}
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "workspace"},
+		{Name: "docker", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run"}}},
+	}
+	ec := &pod.Spec.EphemeralContainers[0]
+	ec.Command = []string{"postgres"}
+	ec.Args = []string{"-c", "fsync=off"}
+	ec.Env = []corev1.EnvVar{{Name: "A", Value: "b"}, {Name: "SECRET", ValueFrom: &corev1.EnvVarSource{}}}
+	ec.SecurityContext = &corev1.SecurityContext{RunAsUser: &uid}
+	ec.VolumeMounts = []corev1.VolumeMount{
+		{Name: "workspace", MountPath: "/src", ReadOnly: true},
+		{Name: "docker", MountPath: "/run/docker.sock", SubPath: "docker.sock"},
+	}
+	pod.Status.PodIP = "10.0.0.7"
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name:    "db",
+		ImageID: "cgr.dev/chainguard/bash@sha256:abc",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{
+				ExitCode:   137,
+				Reason:     "OOMKilled",
+				StartedAt:  metav1.NewTime(started),
+				FinishedAt: metav1.NewTime(finished),
+			},
+		},
+	}}
+
+	c, ok := findContainer(pod, "db")
+	if !ok {
+		t.Fatal("findContainer(db) not found")
+	}
+	got := containerJSON(pod, c)
+
+	wantState := &types.ContainerState{
+		Status:     "exited",
+		OOMKilled:  true,
+		ExitCode:   137,
+		StartedAt:  started.Format(time.RFC3339Nano),
+		FinishedAt: finished.Format(time.RFC3339Nano),
+	}
+	if !reflect.DeepEqual(got.State, wantState) {
+		t.Errorf("State = %+v, want %+v", got.State, wantState)
+	}
+	if got.Created != "2024-03-01T11:59:00Z" {
+		t.Errorf("Created = %s, want 2024-03-01T11:59:00Z", got.Created)
+	}
+	if got.Image != "sha256:abc" {
+		t.Errorf("Image = %s, want sha256:abc", got.Image)
+	}
+	if got.Path != "postgres" || !reflect.DeepEqual(got.Args, []string{"-c", "fsync=off"}) {
+		t.Errorf("Path, Args = %s %v, want postgres [-c fsync=off]", got.Path, got.Args)
+	}
+	if !reflect.DeepEqual(got.Config.Env, []string{"A=b"}) {
+		t.Errorf("Config.Env = %v, want [A=b]", got.Config.Env)
+	}
+	if got.Config.User != "1000" || got.Config.StopSignal != "SIGINT" || got.Config.Hostname != "pod" {
+		t.Errorf("Config = %+v, want user 1000, stop signal SIGINT and hostname pod", got.Config)
+	}
+	if got.Config.Labels["app"] != "db" {
+		t.Errorf("Config.Labels = %v, want app=db", got.Config.Labels)
+	}
+
+	wantMounts := []types.MountPoint{
+		{Type: mount.TypeVolume, Name: "workspace", Destination: "/src"},
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Destination: "/run/docker.sock", RW: true},
+	}
+	if !reflect.DeepEqual(got.Mounts, wantMounts) {
+		t.Errorf("Mounts = %+v, want %+v", got.Mounts, wantMounts)
+	}
+
+	if got.NetworkSettings.IPAddress != "10.0.0.7" || got.NetworkSettings.Networks["pod"].IPAddress != "10.0.0.7" {
+		t.Errorf("NetworkSettings = %+v, want pod IP 10.0.0.7", got.NetworkSettings)
+	}
+}
+
+func TestContainerState(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		status *corev1.ContainerStatus
+		want   string
+	}{
+		{desc: "no status", want: "created"},
+		{desc: "pulling", status: &corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}, want: "created"},
+		{desc: "running", status: &corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}, want: "running"},
+		{desc: "exited", status: &corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}}, want: "exited"},
+		{desc: "crash looping", status: &corev1.ContainerStatus{RestartCount: 3, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}, want: "restarting"},
+	} {
+		if got := containerState(tc.status).Status; got != tc.want {
+			t.Errorf("containerState(%s) = %s, want %s", tc.desc, got, tc.want)
+		}
+	}
+}
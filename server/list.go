@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	units "github.com/docker/go-units"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// acceptedPsFilters are the docker ps filters we support.
+var acceptedPsFilters = map[string]bool{
+	"ancestor": true,
+	"before":   true,
+	"exited":   true,
+	"id":       true,
+	"label":    true,
+	"name":     true,
+	"since":    true,
+	"status":   true,
+}
+
+// validStates are the values accepted by the status filter.
+var validStates = map[string]bool{
+	"created":    true,
+	"restarting": true,
+	"running":    true,
+	"removing":   true,
+	"paused":     true,
+	"exited":     true,
+	"dead":       true,
+}
+
+// listEntry is a container considered for docker ps.
+type listEntry struct {
+	podContainer
+	ref     containerRef
+	status  *corev1.ContainerStatus
+	state   *types.ContainerState
+	labels  map[string]string
+	created time.Time
+}
+
+// listEntries returns the pod's containers, newest first.
+func listEntries(pod *corev1.Pod) []listEntry {
+	var out []listEntry
+	for _, c := range podContainers(pod) {
+		status := containerStatus(pod, c.Instance)
+		state := containerState(status)
+		if c.Ephemeral {
+			meta := containerMetadata(pod, c.Name)
+			markRestarting(state, status, meta)
+			markPaused(state, meta)
+		}
+		out = append(out, listEntry{
+			podContainer: c,
+			ref:          containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: c.Name},
+			status:       status,
+			state:        state,
+			labels:       containerLabels(pod, c),
+			created:      containerCreated(pod, c),
+		})
+	}
+	// Containers created at the same time (e.g. the pod's own) are ordered
+	// by name so the output is stable.
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].created.Equal(out[j].created) {
+			return out[i].created.After(out[j].created)
+		}
+		return out[i].DockerName < out[j].DockerName
+	})
+	return out
+}
+
+// listFilter decides which containers docker ps includes, following the
+// docker daemon's semantics.
+type listFilter struct {
+	filters filters.Args
+	all     bool
+	exited  []int
+	before  *time.Time
+	since   *time.Time
+}
+
+func newListFilter(entries []listEntry, config *container.ListOptions) (*listFilter, error) {
+	psFilters := config.Filters.Clone()
+	if err := psFilters.Validate(acceptedPsFilters); err != nil {
+		return nil, err
+	}
+	// The legacy since and before options are equivalent to the filters.
+	if config.Since != "" {
+		psFilters.Add("since", config.Since)
+	}
+	if config.Before != "" {
+		psFilters.Add("before", config.Before)
+	}
+
+	f := &listFilter{
+		filters: psFilters,
+		// Asking for the latest containers includes stopped ones.
+		all: config.All || config.Latest || config.Limit > 0,
+	}
+
+	if err := psFilters.WalkValues("status", func(value string) error {
+		if !validStates[value] {
+			return errdefs.InvalidParameter(fmt.Errorf("invalid filter 'status=%s'", value))
+		}
+		f.all = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := psFilters.WalkValues("exited", func(value string) error {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return errdefs.InvalidParameter(fmt.Errorf("invalid filter 'exited=%s'", value))
+		}
+		f.exited = append(f.exited, code)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if f.before, err = createdFilter(entries, psFilters, "before"); err != nil {
+		return nil, err
+	}
+	if f.since, err = createdFilter(entries, psFilters, "since"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// createdFilter resolves the container named by a before or since filter to
+// its creation time.
+func createdFilter(entries []listEntry, psFilters filters.Args, key string) (*time.Time, error) {
+	values := psFilters.Get(key)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) > 1 {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("only one %s filter is allowed", key))
+	}
+
+	name := strings.TrimPrefix(values[0], "/")
+	var matches []listEntry
+	for _, e := range entries {
+		if e.DockerName == name || e.ref.ID() == name {
+			return &e.created, nil
+		}
+		if strings.HasPrefix(e.ref.ID(), name) {
+			matches = append(matches, e)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", values[0]))
+	case 1:
+		return &matches[0].created, nil
+	default:
+		return nil, errdefs.InvalidParameter(fmt.Errorf("multiple IDs found with provided prefix: %s", values[0]))
+	}
+}
+
+func (f *listFilter) include(e listEntry) bool {
+	running := e.state.Running || e.state.Paused || e.state.Restarting
+	if !f.all && !running && f.before == nil && f.since == nil {
+		return false
+	}
+	if !f.filters.Match("name", "/"+e.DockerName) {
+		return false
+	}
+	if !f.filters.Match("id", e.ref.ID()) {
+		return false
+	}
+	if !f.filters.MatchKVList("label", e.labels) {
+		return false
+	}
+	if f.filters.Contains("status") && !f.filters.ExactMatch("status", e.state.Status) {
+		return false
+	}
+	if f.before != nil && !e.created.Before(*f.before) {
+		return false
+	}
+	if f.since != nil && !e.created.After(*f.since) {
+		return false
+	}
+	if len(f.exited) > 0 {
+		if e.state.Status != "exited" {
+			return false
+		}
+		found := false
+		for _, code := range f.exited {
+			if code == e.state.ExitCode {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.filters.Contains("ancestor") {
+		found := false
+		for _, ancestor := range f.filters.Get("ancestor") {
+			if matchesImage(e.Image, imageID(e.status), ancestor) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesImage reports whether a container running image (resolved to id)
+// was started from the image ref, which may be a name or an image ID.
+// Images are only known by name, so unlike docker, containers started from
+// an image built on top of ref are not matched.
+func matchesImage(image, id, ref string) bool {
+	if image == ref {
+		return true
+	}
+	if id != "" {
+		want := strings.TrimPrefix(ref, "sha256:")
+		if len(want) >= 12 && strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), want) {
+			return true
+		}
+	}
+	got, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	want, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false
+	}
+	return reference.TagNameOnly(got).String() == reference.TagNameOnly(want).String()
+}
+
+// humanStatus returns the docker ps status column for a container.
+func humanStatus(status *corev1.ContainerStatus, state *types.ContainerState, now time.Time) string {
+	if state.Restarting {
+		// The exit being restarted from is either the current one, or the
+		// previous one if the container is already starting again.
+		var last *corev1.ContainerStateTerminated
+		if status != nil {
+			last = status.State.Terminated
+			if last == nil {
+				last = status.LastTerminationState.Terminated
+			}
+		}
+		if last != nil {
+			return fmt.Sprintf("Restarting (%d) %s ago", last.ExitCode, units.HumanDuration(now.Sub(last.FinishedAt.Time)))
+		}
+		return "Restarting"
+	}
+	if status == nil {
+		return "Created"
+	}
+	switch s := status.State; {
+	case s.Running != nil:
+		if state.Paused {
+			return "Up " + units.HumanDuration(now.Sub(s.Running.StartedAt.Time)) + " (Paused)"
+		}
+		return "Up " + units.HumanDuration(now.Sub(s.Running.StartedAt.Time))
+	case s.Terminated != nil:
+		return fmt.Sprintf("Exited (%d) %s ago", s.Terminated.ExitCode, units.HumanDuration(now.Sub(s.Terminated.FinishedAt.Time)))
+	}
+	return "Created"
+}
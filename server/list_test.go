@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func listTestBackend(t *testing.T) *Backend {
+	t.Helper()
+
+	pod := testPod("ns", "pod", "web", "job", "pending")
+	pod.CreationTimestamp = metav1.NewTime(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{
+			"web": {"created": "2024-03-01T01:00:00Z", "labels": {"tier": "frontend"}},
+			"job": {"created": "2024-03-01T02:00:00Z", "labels": {"tier": "batch"}},
+			"pending": {"created": "2024-03-01T03:00:00Z"}
+		}`,
+	}
+	pod.Spec.EphemeralContainers[1].Image = "alpine"
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "cgr.dev/chainguard/go"}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "main", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+	}
+	// Statuses are deliberately out of order and missing one container.
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{
+		{Name: "job", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 3}}},
+		{Name: "web", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+	}
+	return newTestBackend(t, pod)
+}
+
+func TestContainersList(t *testing.T) {
+	b := listTestBackend(t)
+	ctx := callerContext("ns", "pod")
+
+	for _, tc := range []struct {
+		desc   string
+		config container.ListOptions
+		want   []string
+	}{
+		{desc: "running", want: []string{"web", "main"}},
+		{desc: "all", config: container.ListOptions{All: true}, want: []string{"pending", "job", "web", "main"}},
+		{desc: "limit", config: container.ListOptions{Limit: 2}, want: []string{"pending", "job"}},
+		{desc: "latest", config: container.ListOptions{Latest: true}, want: []string{"pending"}},
+		{desc: "status", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("status", "exited"))}, want: []string{"job"}},
+		{desc: "status created", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("status", "created"))}, want: []string{"pending"}},
+		{desc: "name", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("name", "^/j"))}, want: []string{"job"}},
+		{desc: "id", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("id", containerRef{"ns", "pod", "web"}.ID()[:12]))}, want: []string{"web"}},
+		{desc: "label", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("label", "tier=batch"))}, want: []string{"job"}},
+		{desc: "label key", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("label", "tier"))}, want: []string{"job", "web"}},
+		{desc: "managed", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=false"))}, want: []string{"main"}},
+		{desc: "ancestor", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("ancestor", "docker.io/library/alpine:latest"))}, want: []string{"job"}},
+		{desc: "exited", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("exited", "3"))}, want: []string{"job"}},
+		{desc: "exited other code", config: container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("exited", "0"))}, want: nil},
+		{desc: "before", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("before", "job"))}, want: []string{"web", "main"}},
+		{desc: "since", config: container.ListOptions{Filters: filters.NewArgs(filters.Arg("since", "web"))}, want: []string{"pending", "job"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			list, err := b.Containers(ctx, &tc.config)
+			if err != nil {
+				t.Fatalf("Containers() = %v", err)
+			}
+			var got []string
+			for _, c := range list {
+				got = append(got, strings.TrimPrefix(c.Names[0], "/"))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Containers() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		filters filters.Args
+	}{
+		{desc: "unknown filter", filters: filters.NewArgs(filters.Arg("volume", "x"))},
+		{desc: "bad status", filters: filters.NewArgs(filters.Arg("status", "sleeping"))},
+		{desc: "bad exit code", filters: filters.NewArgs(filters.Arg("exited", "x"))},
+	} {
+		if _, err := b.Containers(ctx, &container.ListOptions{Filters: tc.filters}); !errdefs.IsInvalidParameter(err) {
+			t.Errorf("Containers(%s) = %v, want invalid parameter error", tc.desc, err)
+		}
+	}
+	if _, err := b.Containers(ctx, &container.ListOptions{Filters: filters.NewArgs(filters.Arg("since", "missing"))}); !errdefs.IsNotFound(err) {
+		t.Errorf("Containers(since=missing) = %v, want not found error", err)
+	}
+}
+
+func TestContainersListState(t *testing.T) {
+	b := listTestBackend(t)
+	list, err := b.Containers(callerContext("ns", "pod"), &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	got := map[string]string{}
+	for _, c := range list {
+		got[c.Names[0]] = c.State
+	}
+	want := map[string]string{
+		"/pending": "created",
+		"/job":     "exited",
+		"/web":     "running",
+		"/main":    "running",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Containers() states = %v, want %v", got, want)
+	}
+}
+
+func TestHumanStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := metav1.NewTime(now.Add(-5 * time.Minute))
+	for _, tc := range []struct {
+		desc   string
+		status *corev1.ContainerStatus
+		want   string
+	}{
+		{desc: "no status", want: "Created"},
+		{
+			desc:   "running",
+			status: &corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: ago}}},
+			want:   "Up 5 minutes",
+		},
+		{
+			desc:   "exited",
+			status: &corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, FinishedAt: ago}}},
+			want:   "Exited (1) 5 minutes ago",
+		},
+		{
+			desc: "restarting",
+			status: &corev1.ContainerStatus{
+				RestartCount:         1,
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2, FinishedAt: ago}},
+			},
+			want: "Restarting (2) 5 minutes ago",
+		},
+	} {
+		if got := humanStatus(tc.status, containerState(tc.status), now); got != tc.want {
+			t.Errorf("humanStatus(%s) = %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
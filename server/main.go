@@ -3,19 +3,21 @@ package main
 import (
 	"context"
 	"crypto/x509"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/docker/docker/api/server"
 	"github.com/docker/docker/api/server/middleware"
 	"github.com/docker/docker/api/server/router/container"
 	"github.com/docker/docker/api/server/router/image"
 	"github.com/docker/docker/api/server/router/system"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/runconfig"
 	"github.com/sirupsen/logrus"
 	"github.com/wlynch/levias/pkg/token"
@@ -26,7 +28,18 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+var (
+	execStore     = flag.String("exec-store", "memory", "where to persist exec sessions: memory or configmap")
+	execConfigMap = flag.String("exec-configmap", "levias-execs", "name prefix and label value of the ConfigMaps used by -exec-store=configmap, one per session")
+	execTTL       = flag.Duration("exec-ttl", time.Hour, "how long finished exec sessions are kept")
+	execMax       = flag.Int("exec-max", 1024, "maximum number of exec sessions kept")
+	startTimeout  = flag.Duration("start-timeout", 5*time.Minute, "how long to wait for a container to start")
+	maxInstances  = flag.Int("max-instances", 100, "maximum number of ephemeral containers a container can take up in its pod by restarting, since Kubernetes never removes them; 0 means no limit")
+	strict        = flag.Bool("strict", true, "reject docker options that ephemeral containers can't honor, like resource limits or published ports, instead of ignoring them with a warning")
+)
+
 func main() {
+	flag.Parse()
 	ctx := context.Background()
 
 	logrus.SetLevel(logrus.DebugLevel)
@@ -58,19 +71,49 @@ func main() {
 		addRoutes(r, srv)
 	*/
 
+	var persister ExecPersister
+	switch *execStore {
+	case "memory":
+		persister = NewMemoryExecPersister()
+	case "configmap":
+		persister = NewConfigMapExecPersister(clientset, serverNamespace(), *execConfigMap)
+	default:
+		log.Fatalf("unknown exec store %q", *execStore)
+	}
+	execs := NewExecStore(persister, *execTTL, *execMax)
+	if err := execs.Restore(ctx); err != nil {
+		log.Fatalf("failed to restore exec sessions: %v", err)
+	}
+
+	pods := newPodWatcher(clientset)
+	events := newEventBus(pods)
+	if err := pods.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+
 	b := &Backend{
-		mu:       new(sync.RWMutex),
-		config:   config,
-		client:   clientset,
-		verifier: verifier,
+		config:    config,
+		client:    clientset,
+		verifier:  verifier,
+		terminals: newTerminals(),
+		execs:     execs,
+		pods:      pods,
+		podLocks:  newPodLocks(),
+		events:    events,
+
+		startTimeout: *startTimeout,
+		maxInstances: *maxInstances,
+		strict:       *strict,
 	}
+	newSupervisor(ctx, b)
 	s := &server.Server{}
 	vm, err := middleware.NewVersionMiddleware("1.45", "1.45", "1.45")
 	if err != nil {
 		log.Fatalf("failed to create version middleware: %v", err)
 	}
 	s.UseMiddleware(&logmiddleware{})
-	s.UseMiddleware(&nameTransform{})
+	s.UseMiddleware(&nameTransform{b: b})
+	s.UseMiddleware(&eventScope{})
 	s.UseMiddleware(vm)
 	s.UseMiddleware(&AuthMiddleware{verifier: verifier})
 
@@ -98,6 +141,14 @@ func main() {
 	}
 }
 
+// serverNamespace returns the namespace the server is running in.
+func serverNamespace() string {
+	if ns, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		return strings.TrimSpace(string(ns))
+	}
+	return "default"
+}
+
 func internalClient(ts oauth2.TokenSource) *http.Client {
 	// Add the Kubernetes cluster's CA to the system CA pool, and to
 	// the default transport.
@@ -136,20 +187,73 @@ func (l *logmiddleware) WrapHandler(handler func(ctx context.Context, w http.Res
 }
 
 // This is a big hack because moby backend API isn't consistent about plumbing through contexts.
-// This ensures that names always have the same scheme when needed.
-type nameTransform struct{}
+// This resolves docker container names and IDs to a containerRef in the caller's pod, and makes
+// sure callers can only address execs in their own pod.
+type nameTransform struct {
+	b *Backend
+}
 
 func (l *nameTransform) WrapHandler(handler func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error) func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-		name, ok := vars["name"]
-		if ok {
-			if len(strings.Split(name, ".")) < 3 {
-				ns, pod, err := getPod(ctx)
-				if err != nil {
+		// Only container and exec routes take container and exec IDs - image
+		// routes use the same variable names for image references.
+		path := trimAPIVersion(r.URL.Path)
+		switch {
+		case strings.HasPrefix(path, "/containers/"):
+			if name, ok := vars["name"]; ok {
+				ref, err := l.b.resolveContainer(ctx, name)
+				switch {
+				case errdefs.IsNotFound(err):
+					// Let the backend decide what to do with names that don't
+					// exist (yet), scoped to the caller's pod.
+					ref = containerRef{Namespace: GetNamespace(ctx), Pod: GetPod(ctx), Name: strings.TrimPrefix(name, "/")}
+					if l.b.renamedFrom(ref) {
+						return err
+					}
+				case err != nil:
 					return err
 				}
-				vars["name"] = strings.Join([]string{ns, pod, name}, ".")
+				vars["name"] = ref.String()
+			}
+		case strings.HasPrefix(path, "/exec/"):
+			// Exec start and resize use name, exec inspect uses id.
+			for _, k := range []string{"name", "id"} {
+				if id, ok := vars[k]; ok {
+					if err := l.b.checkExecAccess(ctx, id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return handler(ctx, w, r, vars)
+	}
+}
+
+// trimAPIVersion strips the optional /v1.xx prefix from an API path.
+func trimAPIVersion(path string) string {
+	if strings.HasPrefix(path, "/v") {
+		if i := strings.Index(path[1:], "/"); i >= 0 {
+			return path[i+1:]
+		}
+	}
+	return path
+}
+
+// eventScope limits event subscriptions to events from the caller's pod.
+type eventScope struct{}
+
+func (l *eventScope) WrapHandler(handler func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error) func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			ns, pod, err := getPod(ctx)
+			if err != nil {
+				return err
+			}
+			query := r.URL.Query()
+			if err := scopeEventFilters(query, ns, pod); err != nil {
+				return errdefs.InvalidParameter(err)
 			}
+			r.URL.RawQuery = query.Encode()
 		}
 		return handler(ctx, w, r, vars)
 	}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// metadataAnnotation holds docker metadata for the pod's levias
+	// containers that has no equivalent in the ephemeral container spec.
+	metadataAnnotation = "levias.io/containers"
+)
+
+// containerMeta is the docker metadata stored for a container.
+type containerMeta struct {
+	Created     time.Time         `json:"created"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	User        string            `json:"user,omitempty"`
+	StopSignal  string            `json:"stopSignal,omitempty"`
+	StopTimeout *int              `json:"stopTimeout,omitempty"`
+	// ConsoleSize is the {height, width} the client asked for on create,
+	// which TTY attach sessions start out at.
+	ConsoleSize *[2]uint `json:"consoleSize,omitempty"`
+	// Instances are the ephemeral containers that replaced the original one
+	// when it was restarted, oldest first. The original is named after the
+	// container.
+	Instances []string `json:"instances,omitempty"`
+	// RestartPolicy is the container's restart policy, if it has one.
+	RestartPolicy *container.RestartPolicy `json:"restartPolicy,omitempty"`
+	// RestartCount is the number of times the restart policy restarted the
+	// container since it was last started through docker.
+	RestartCount int `json:"restartCount,omitempty"`
+	// Stopped is set when the container was stopped through docker, which
+	// keeps its restart policy from restarting it.
+	Stopped bool `json:"stopped,omitempty"`
+	// AutoRemove removes the container once it exits (docker run --rm).
+	AutoRemove bool `json:"autoRemove,omitempty"`
+	// Removed is set once the container has been removed through docker.
+	Removed bool `json:"removed,omitempty"`
+	// Paused is set while the container is paused through docker. It only
+	// applies to the running instance.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// podMetadata returns the metadata stored on the pod, keyed by container name.
+func podMetadata(pod *corev1.Pod) map[string]*containerMeta {
+	out := map[string]*containerMeta{}
+	raw, ok := pod.Annotations[metadataAnnotation]
+	if !ok {
+		return out
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		fmt.Printf("ignoring malformed metadata on pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+		return map[string]*containerMeta{}
+	}
+	return out
+}
+
+// containerMetadata returns the metadata stored for a container, or empty
+// metadata if there is none (e.g. it wasn't created by levias).
+func containerMetadata(pod *corev1.Pod, name string) *containerMeta {
+	if meta, ok := podMetadata(pod)[name]; ok && meta != nil {
+		return meta
+	}
+	return &containerMeta{}
+}
+
+// updateMetadata applies mutate to the pod's container metadata and saves it.
+func (b *Backend) updateMetadata(ctx context.Context, namespace, name string, mutate func(map[string]*containerMeta) error) error {
+	unlock := b.podLocks.Lock(namespace, name)
+	defer unlock()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := b.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		meta := podMetadata(pod)
+		if err := mutate(meta); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[metadataAnnotation] = string(raw)
+		_, err = b.client.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+}
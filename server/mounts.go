@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// translateMounts resolves docker binds, mounts and volumes-from to volume
+// mounts.
+// Ephemeral containers can only mount volumes already declared on the pod, so
+// bind sources must be paths inside a volume mounted by one of the pod's
+// containers and named volumes must be pod volumes.
+func translateMounts(pod *corev1.Pod, hc *container.HostConfig) ([]corev1.VolumeMount, error) {
+	if hc == nil {
+		return nil, nil
+	}
+
+	inherited, err := volumesFrom(pod, hc.VolumesFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []corev1.VolumeMount
+	for _, bind := range hc.Binds {
+		src, dst, readOnly, err := parseBind(bind)
+		if err != nil {
+			return nil, err
+		}
+		vm, err := resolveMount(pod, src, dst, readOnly)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vm)
+	}
+	for _, m := range hc.Mounts {
+		switch m.Type {
+		case mount.TypeBind, mount.TypeVolume:
+		default:
+			return nil, errdefs.InvalidParameter(fmt.Errorf("mount type %q is not supported, only bind and volume mounts of pod volumes are", m.Type))
+		}
+		if m.Source == "" {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("anonymous volume for %s is not supported, mount a pod volume instead", m.Target))
+		}
+		vm, err := resolveMount(pod, m.Source, m.Target, m.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vm)
+	}
+
+	seen := map[string]bool{}
+	for _, vm := range out {
+		if seen[vm.MountPath] {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("duplicate mount point: %s", vm.MountPath))
+		}
+		seen[vm.MountPath] = true
+	}
+	// Like docker, explicit mounts take precedence over inherited ones.
+	for _, vm := range inherited {
+		if !seen[vm.MountPath] {
+			out = append(out, vm)
+		}
+	}
+	return out, nil
+}
+
+// volumesFrom returns the volume mounts inherited from --volumes-from
+// containers, which may be any of the pod's regular or ephemeral containers.
+func volumesFrom(pod *corev1.Pod, specs []string) ([]corev1.VolumeMount, error) {
+	var out []corev1.VolumeMount
+	byPath := map[string]corev1.VolumeMount{}
+	for _, spec := range specs {
+		name, mode, _ := strings.Cut(spec, ":")
+		switch mode {
+		case "", "ro", "rw":
+		default:
+			return nil, errdefs.InvalidParameter(fmt.Errorf("invalid mode for volumes-from: %s", mode))
+		}
+
+		mounts, err := containerVolumeMounts(pod, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range mounts {
+			if vm.SubPathExpr != "" {
+				return nil, errdefs.InvalidParameter(fmt.Errorf("volume %q of container %s is mounted with subPathExpr, which can't be shared", vm.Name, name))
+			}
+			vm.MountPath = path.Clean(vm.MountPath)
+			if mode != "" {
+				vm.ReadOnly = mode == "ro"
+			}
+			if prev, ok := byPath[vm.MountPath]; ok {
+				// Containers commonly mount the same volume at the same
+				// path, which is fine. Anything else is a real conflict.
+				if !reflect.DeepEqual(prev, vm) {
+					return nil, errdefs.InvalidParameter(fmt.Errorf("duplicate mount point: %s", vm.MountPath))
+				}
+				continue
+			}
+			byPath[vm.MountPath] = vm
+			out = append(out, vm)
+		}
+	}
+	return out, nil
+}
+
+// containerVolumeMounts returns the volume mounts of the pod's regular
+// container with the given name, or of the ephemeral container matching the
+// given name or ID.
+func containerVolumeMounts(pod *corev1.Pod, name string) ([]corev1.VolumeMount, error) {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return c.VolumeMounts, nil
+		}
+	}
+	ref, ok, err := matchContainer(pod, name)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if c, ok := findContainer(pod, ref.Name); ok {
+			return c.VolumeMounts, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", name))
+}
+
+// parseBind parses a docker bind spec (src:dst[:opts]).
+func parseBind(bind string) (string, string, bool, error) {
+	parts := strings.Split(bind, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", false, errdefs.InvalidParameter(fmt.Errorf("invalid volume specification: %q", bind))
+	}
+	readOnly := false
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			switch opt {
+			case "ro":
+				readOnly = true
+			case "rw":
+				readOnly = false
+			}
+		}
+	}
+	return parts[0], parts[1], readOnly, nil
+}
+
+// resolveMount returns the volume mount that makes src available at dst.
+// src is either a pod volume name or an absolute path in one of the pod's
+// containers.
+func resolveMount(pod *corev1.Pod, src, dst string, readOnly bool) (corev1.VolumeMount, error) {
+	if !path.IsAbs(dst) {
+		return corev1.VolumeMount{}, errdefs.InvalidParameter(fmt.Errorf("invalid mount path %q: must be absolute", dst))
+	}
+	dst = path.Clean(dst)
+
+	if !path.IsAbs(src) {
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == src {
+				return corev1.VolumeMount{
+					Name:      v.Name,
+					MountPath: dst,
+					ReadOnly:  readOnly,
+				}, nil
+			}
+		}
+		return corev1.VolumeMount{}, errdefs.InvalidParameter(fmt.Errorf("volume %q is not a volume of pod %s", src, pod.Name))
+	}
+
+	src = path.Clean(src)
+	vm, err := backingMount(pod, src)
+	if err != nil {
+		return corev1.VolumeMount{}, err
+	}
+	if vm.SubPathExpr != "" {
+		return corev1.VolumeMount{}, errdefs.InvalidParameter(fmt.Errorf("%s is backed by volume %q mounted with subPathExpr, which can't be bind mounted", src, vm.Name))
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(src, vm.MountPath), "/")
+	return corev1.VolumeMount{
+		Name:      vm.Name,
+		MountPath: dst,
+		SubPath:   path.Join(vm.SubPath, rel),
+		ReadOnly:  readOnly || vm.ReadOnly,
+	}, nil
+}
+
+// backingMount finds the volume mount of the pod's containers that contains
+// src. The deepest mount wins, like it would in the container itself.
+func backingMount(pod *corev1.Pod, src string) (corev1.VolumeMount, error) {
+	var matches []corev1.VolumeMount
+	for _, c := range pod.Spec.Containers {
+		for _, vm := range c.VolumeMounts {
+			vm.MountPath = path.Clean(vm.MountPath)
+			if vm.MountPath == src || vm.MountPath == "/" || strings.HasPrefix(src, vm.MountPath+"/") {
+				matches = append(matches, vm)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return corev1.VolumeMount{}, errdefs.InvalidParameter(fmt.Errorf("bind source %s is not backed by a pod volume: only paths inside volumes mounted in the pod can be shared", src))
+	}
+
+	best := matches[0]
+	for _, vm := range matches[1:] {
+		if len(vm.MountPath) > len(best.MountPath) {
+			best = vm
+		}
+	}
+	for _, vm := range matches {
+		// The pod's containers mount different volumes at the same path, so
+		// we can't tell which one the caller means.
+		if vm.MountPath == best.MountPath && (vm.Name != best.Name || vm.SubPath != best.SubPath) {
+			return corev1.VolumeMount{}, errdefs.InvalidParameter(fmt.Errorf("bind source %s is ambiguous: volumes %q and %q are both mounted at %s", src, best.Name, vm.Name, vm.MountPath))
+		}
+	}
+	return best, nil
+}
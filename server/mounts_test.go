@@ -0,0 +1,181 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTranslateMounts(t *testing.T) {
+	pod := testPod("ns", "pod")
+	pod.Spec.Volumes = []corev1.Volume{{Name: "workspace"}, {Name: "cache"}, {Name: "config"}}
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "main",
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "workspace", MountPath: "/workspace"},
+			{Name: "cache", MountPath: "/workspace/.cache/", SubPath: "go"},
+			{Name: "config", MountPath: "/etc/config", ReadOnly: true},
+		},
+	}}
+
+	for _, tc := range []struct {
+		desc string
+		hc   *container.HostConfig
+		want []corev1.VolumeMount
+	}{
+		{
+			desc: "volume root",
+			hc:   &container.HostConfig{Binds: []string{"/workspace:/src"}},
+			want: []corev1.VolumeMount{{Name: "workspace", MountPath: "/src"}},
+		},
+		{
+			desc: "subdirectory",
+			hc:   &container.HostConfig{Binds: []string{"/workspace/repo/./:/src:ro"}},
+			want: []corev1.VolumeMount{{Name: "workspace", MountPath: "/src", SubPath: "repo", ReadOnly: true}},
+		},
+		{
+			desc: "deepest mount wins",
+			hc:   &container.HostConfig{Binds: []string{"/workspace/.cache/mod:/go/pkg/mod"}},
+			want: []corev1.VolumeMount{{Name: "cache", MountPath: "/go/pkg/mod", SubPath: "go/mod"}},
+		},
+		{
+			desc: "read-only volume stays read-only",
+			hc:   &container.HostConfig{Binds: []string{"/etc/config:/config:rw"}},
+			want: []corev1.VolumeMount{{Name: "config", MountPath: "/config", ReadOnly: true}},
+		},
+		{
+			desc: "named volume",
+			hc:   &container.HostConfig{Binds: []string{"cache:/cache"}},
+			want: []corev1.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+		},
+		{
+			desc: "mounts",
+			hc: &container.HostConfig{Mounts: []mount.Mount{
+				{Type: mount.TypeBind, Source: "/workspace/out", Target: "/out", ReadOnly: true},
+				{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+			}},
+			want: []corev1.VolumeMount{
+				{Name: "workspace", MountPath: "/out", SubPath: "out", ReadOnly: true},
+				{Name: "cache", MountPath: "/cache"},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := translateMounts(pod, tc.hc)
+			if err != nil {
+				t.Fatalf("translateMounts() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("translateMounts() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		desc string
+		hc   *container.HostConfig
+	}{
+		{desc: "not backed by a volume", hc: &container.HostConfig{Binds: []string{"/home/user:/src"}}},
+		{desc: "prefix is not a parent", hc: &container.HostConfig{Binds: []string{"/workspace2:/src"}}},
+		{desc: "unknown named volume", hc: &container.HostConfig{Binds: []string{"data:/data"}}},
+		{desc: "relative target", hc: &container.HostConfig{Binds: []string{"/workspace:src"}}},
+		{desc: "malformed", hc: &container.HostConfig{Binds: []string{"/workspace"}}},
+		{desc: "duplicate target", hc: &container.HostConfig{Binds: []string{"/workspace:/src", "cache:/src"}}},
+		{desc: "tmpfs", hc: &container.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeTmpfs, Target: "/tmp"}}}},
+		{desc: "anonymous volume", hc: &container.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeVolume, Target: "/data"}}}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := translateMounts(pod, tc.hc); !errdefs.IsInvalidParameter(err) {
+				t.Errorf("translateMounts() = %v, want invalid parameter error", err)
+			}
+		})
+	}
+}
+
+func TestBackingMountAmbiguous(t *testing.T) {
+	pod := testPod("ns", "pod")
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "a", VolumeMounts: []corev1.VolumeMount{{Name: "one", MountPath: "/data"}}},
+		{Name: "b", VolumeMounts: []corev1.VolumeMount{{Name: "two", MountPath: "/data"}, {Name: "three", MountPath: "/data/sub"}}},
+	}
+
+	if _, err := backingMount(pod, "/data/x"); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("backingMount(/data/x) = %v, want invalid parameter error", err)
+	}
+	// A deeper mount resolves the ambiguity.
+	got, err := backingMount(pod, "/data/sub/x")
+	if err != nil {
+		t.Fatalf("backingMount(/data/sub/x) = %v", err)
+	}
+	if got.Name != "three" {
+		t.Errorf("backingMount(/data/sub/x) = %s, want three", got.Name)
+	}
+}
+
+func TestVolumesFrom(t *testing.T) {
+	pod := testPod("ns", "pod", "builder")
+	pod.Spec.Volumes = []corev1.Volume{{Name: "workspace"}, {Name: "cache"}}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "main", VolumeMounts: []corev1.VolumeMount{{Name: "workspace", MountPath: "/workspace"}}},
+		{Name: "sidecar", VolumeMounts: []corev1.VolumeMount{{Name: "workspace", MountPath: "/workspace"}}},
+	}
+	pod.Spec.EphemeralContainers[0].VolumeMounts = []corev1.VolumeMount{{Name: "cache", MountPath: "/cache"}}
+	builder := containerRef{Namespace: "ns", Pod: "pod", Name: "builder"}
+
+	for _, tc := range []struct {
+		desc string
+		hc   *container.HostConfig
+		want []corev1.VolumeMount
+	}{
+		{
+			desc: "regular container",
+			hc:   &container.HostConfig{VolumesFrom: []string{"main"}},
+			want: []corev1.VolumeMount{{Name: "workspace", MountPath: "/workspace"}},
+		},
+		{
+			desc: "ephemeral container by ID",
+			hc:   &container.HostConfig{VolumesFrom: []string{builder.ID()[:12] + ":ro"}},
+			want: []corev1.VolumeMount{{Name: "cache", MountPath: "/cache", ReadOnly: true}},
+		},
+		{
+			desc: "same mount from several containers",
+			hc:   &container.HostConfig{VolumesFrom: []string{"main", "sidecar", "builder"}},
+			want: []corev1.VolumeMount{
+				{Name: "workspace", MountPath: "/workspace"},
+				{Name: "cache", MountPath: "/cache"},
+			},
+		},
+		{
+			desc: "binds take precedence",
+			hc: &container.HostConfig{
+				VolumesFrom: []string{"main", "builder"},
+				Binds:       []string{"/workspace/cache:/cache"},
+			},
+			want: []corev1.VolumeMount{
+				{Name: "workspace", MountPath: "/cache", SubPath: "cache"},
+				{Name: "workspace", MountPath: "/workspace"},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := translateMounts(pod, tc.hc)
+			if err != nil {
+				t.Fatalf("translateMounts() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("translateMounts() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := translateMounts(pod, &container.HostConfig{VolumesFrom: []string{"missing"}}); !errdefs.IsNotFound(err) {
+		t.Errorf("translateMounts(missing) = %v, want not found error", err)
+	}
+	if _, err := translateMounts(pod, &container.HostConfig{VolumesFrom: []string{"main:z"}}); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("translateMounts(main:z) = %v, want invalid parameter error", err)
+	}
+}
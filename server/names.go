@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// namesAnnotation maps the docker names of the pod's levias containers
+	// to the name they were created with, for containers whose docker name
+	// differs: renamed containers, containers created under a name
+	// Kubernetes still holds for a removed or renamed one, and containers
+	// whose docker name isn't a valid Kubernetes container name.
+	namesAnnotation = "levias.io/names"
+)
+
+// validDockerName matches the names the docker daemon accepts.
+var validDockerName = regexp.MustCompile(`^/?[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// validateDockerName checks a docker name the way the docker daemon does.
+// Unlike container names, docker names never reach Kubernetes.
+func validateDockerName(name string) error {
+	if !validDockerName.MatchString(name) {
+		return errdefs.InvalidParameter(fmt.Errorf("Invalid container name (%s), only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name))
+	}
+	return nil
+}
+
+// podNames returns the pod's name table, keyed by docker name.
+func podNames(pod *corev1.Pod) map[string]string {
+	out := map[string]string{}
+	raw, ok := pod.Annotations[namesAnnotation]
+	if !ok {
+		return out
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		fmt.Printf("ignoring malformed container names on pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+		return map[string]string{}
+	}
+	return out
+}
+
+// dockerName returns the docker name of the container created as name.
+func dockerName(names map[string]string, name string) string {
+	for alias, target := range names {
+		if target == name {
+			return alias
+		}
+	}
+	return name
+}
+
+// findDockerName returns the pod's container docker knows by name.
+func findDockerName(pod *corev1.Pod, name string) (podContainer, bool) {
+	for _, c := range podContainers(pod) {
+		if c.DockerName == name {
+			return c, true
+		}
+	}
+	return podContainer{}, false
+}
+
+// nameConflict is the error for a docker name that's already in use by the
+// named container.
+func nameConflict(pod *corev1.Pod, name, owner string) error {
+	ref := containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: owner}
+	return errdefs.Conflict(fmt.Errorf("Conflict. The container name %q is already in use by container %q. You have to remove (or rename) that container to be able to reuse that name.", "/"+name, ref.ID()))
+}
+
+// updateNames applies mutate to the pod's name table and saves it, if it
+// changed.
+func (b *Backend) updateNames(ctx context.Context, namespace, name string, mutate func(*corev1.Pod, map[string]string) error) error {
+	unlock := b.podLocks.Lock(namespace, name)
+	defer unlock()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := b.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		names := podNames(pod)
+		if err := mutate(pod, names); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(names)
+		if err != nil {
+			return err
+		}
+		if old, ok := pod.Annotations[namesAnnotation]; old == string(raw) || !ok && len(names) == 0 {
+			return nil
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[namesAnnotation] = string(raw)
+		_, err = b.client.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// renamedFrom reports whether ref names a container by the name it was
+// created with, which docker no longer knows it by since it was renamed.
+func (b *Backend) renamedFrom(ref containerRef) bool {
+	pod, err := b.pods.Get(ref.Namespace, ref.Pod)
+	if err != nil || pod == nil {
+		return false
+	}
+	c, ok := findContainer(pod, ref.Name)
+	return ok && c.DockerName != c.Name
+}
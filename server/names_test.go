@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerRename(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "db")
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "cgr.dev/chainguard/bash"}}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	db := containerRef{Namespace: "ns", Pod: "pod", Name: "db"}
+	regular := containerRef{Namespace: "ns", Pod: "pod", Name: "main"}
+
+	if err := b.ContainerRename(web.String(), "/api"); err != nil {
+		t.Fatalf("ContainerRename(web, api) = %v", err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		ref  containerRef
+		name string
+		want func(error) bool
+	}{
+		{desc: "in use", ref: db, name: "api", want: errdefs.IsConflict},
+		{desc: "regular container's name", ref: db, name: "main", want: errdefs.IsConflict},
+		{desc: "same name", ref: db, name: "db", want: errdefs.IsInvalidParameter},
+		{desc: "empty", ref: db, name: "", want: errdefs.IsInvalidParameter},
+		{desc: "invalid", ref: db, name: "-leading-dash", want: errdefs.IsInvalidParameter},
+		{desc: "invalid character", ref: db, name: "has space", want: errdefs.IsInvalidParameter},
+		{desc: "regular container", ref: regular, name: "other", want: errdefs.IsForbidden},
+	} {
+		if err := b.ContainerRename(tc.ref.String(), tc.name); !tc.want(err) {
+			t.Errorf("%s: ContainerRename(%s, %q) = %v", tc.desc, tc.ref.Name, tc.name, err)
+		}
+	}
+
+	got, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok, _ := matchContainer(got, "api"); !ok || ref != web {
+		t.Errorf("matchContainer(api) = %v, %t, want %v", ref, ok, web)
+	}
+	if ref, ok, _ := matchContainer(got, "web"); ok {
+		t.Errorf("matchContainer(web) = %v, want no match", ref)
+	}
+
+	list, err := b.Containers(ctx, &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	for _, c := range list {
+		if c.ID == web.ID() && c.Names[0] != "/api" {
+			t.Errorf("Containers() names = %v, want [/api]", c.Names)
+		}
+	}
+
+	// The old name is free again, even though Kubernetes still holds it.
+	created, err := b.ContainerCreate(ctx, backend.ContainerCreateConfig{
+		Name:   "web",
+		Config: &container.Config{Image: "cgr.dev/chainguard/bash"},
+	})
+	if err != nil {
+		t.Fatalf("ContainerCreate(web) = %v", err)
+	}
+	if created.ID == web.ID() {
+		t.Error("ContainerCreate(web) reused the renamed container's ID")
+	}
+	if err := b.ContainerRename(web.String(), "web"); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerRename(api, web) = %v, want conflict", err)
+	}
+
+	// Renaming a container back to the name it was created with drops it
+	// from the name table.
+	if err := b.ContainerRename(db.String(), "cache"); err != nil {
+		t.Fatalf("ContainerRename(db, cache) = %v", err)
+	}
+	if err := b.ContainerRename(db.String(), "db"); err != nil {
+		t.Fatalf("ContainerRename(cache, db) = %v", err)
+	}
+	if got, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	names := podNames(got)
+	if len(names) != 2 || names["api"] != "web" || names["cache"] != "" {
+		t.Errorf("podNames() = %v, want api and the new web only", names)
+	}
+
+	// Docker names aren't held to Kubernetes' naming rules.
+	if err := b.ContainerRename(db.String(), "/My_Cache.1"); err != nil {
+		t.Fatalf("ContainerRename(db, My_Cache.1) = %v", err)
+	}
+	if got, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok, _ := matchContainer(got, "My_Cache.1"); !ok || ref != db {
+		t.Errorf("matchContainer(My_Cache.1) = %v, %t, want %v", ref, ok, db)
+	}
+}
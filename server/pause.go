@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// freezeScript sends signal $1 (STOP or CONT) to every process in the
+// container, except itself. Docker freezes containers with the cgroup
+// freezer, which we have no access to, so pausing stops the processes
+// instead.
+//
+// SIGSTOP is ignored by PID 1 when sent from inside its own PID
+// namespace, so containers can only be paused if the pod shares its process
+// namespace.
+const freezeScript = `sig=$1` + findMainScript + `
+if [ "$sig" = STOP ] && [ "$main" = 1 ]; then
+	echo "the container's main process runs as PID 1, which can't be stopped from inside the container. Use shareProcessNamespace on the pod to allow pausing it" >&2
+	exit 2
+fi
+for d in /proc/[0-9]*; do
+	[ "$d/ns/mnt" -ef /proc/self/ns/mnt ] || continue
+	[ "${d#/proc/}" = "$$" ] && continue
+	kill -"$sig" "${d#/proc/}" 2>/dev/null
+done
+exit 0
+`
+
+// isPaused reports whether the container has been paused through docker and
+// is still running.
+func isPaused(pod *corev1.Pod, c podContainer) bool {
+	if !c.Ephemeral {
+		return false
+	}
+	status := containerStatus(pod, c.Instance)
+	return status != nil && status.State.Running != nil && containerMetadata(pod, c.Name).Paused
+}
+
+// markPaused reports a running container that was paused through docker as
+// paused. Like docker, paused containers are still running.
+func markPaused(state *types.ContainerState, meta *containerMeta) {
+	if state.Running && meta.Paused {
+		state.Paused = true
+		state.Status = "paused"
+	}
+}
+
+// freeze stops every process in the container and records it as paused.
+func (b *Backend) freeze(ctx context.Context, ref containerRef, instance string) error {
+	if err := b.signalAll(ctx, ref.Namespace, ref.Pod, instance, "STOP"); err != nil {
+		return err
+	}
+	if err := b.setPaused(ctx, ref, true); err != nil {
+		// Don't leave the container frozen without anyone knowing.
+		if err := b.signalAll(ctx, ref.Namespace, ref.Pod, instance, "CONT"); err != nil {
+			fmt.Printf("error resuming container %s: %v\n", ref, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// thaw resumes every process in the container and records it as no longer
+// paused.
+func (b *Backend) thaw(ctx context.Context, ref containerRef, instance string) error {
+	if err := b.signalAll(ctx, ref.Namespace, ref.Pod, instance, "CONT"); err != nil {
+		return err
+	}
+	return b.setPaused(ctx, ref, false)
+}
+
+// signalAll delivers the named signal to every process in the container.
+func (b *Backend) signalAll(ctx context.Context, namespace, pod, container, sig string) error {
+	var stderr bytes.Buffer
+	err := b.exec(ctx, namespace, pod, container, []string{"sh", "-c", freezeScript, "sh", sig}, remotecommand.StreamOptions{
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return errdefs.System(fmt.Errorf("failed to signal container %s: %v: %s", container, err, bytes.TrimSpace(stderr.Bytes())))
+	}
+	return nil
+}
+
+func (b *Backend) setPaused(ctx context.Context, ref containerRef, paused bool) error {
+	return b.updateMetadata(ctx, ref.Namespace, ref.Pod, func(m map[string]*containerMeta) error {
+		meta := m[ref.Name]
+		if meta == nil {
+			meta = &containerMeta{}
+			m[ref.Name] = meta
+		}
+		meta.Paused = paused
+		return nil
+	})
+}
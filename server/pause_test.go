@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPaused(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "job")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","paused":true},"job":{"created":"2024-01-01T00:00:00Z","paused":true}}`,
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}, {
+		// Paused containers that have exited since aren't paused.
+		Name: "job",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 137},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	job := containerRef{Namespace: "ns", Pod: "pod", Name: "job"}
+
+	got, err := b.ContainerInspect(ctx, web.String(), false, "1.45")
+	if err != nil {
+		t.Fatalf("ContainerInspect(web) = %v", err)
+	}
+	if state := got.(types.ContainerJSON).State; !state.Paused || !state.Running || state.Status != "paused" {
+		t.Errorf("ContainerInspect(web) state = %+v, want paused", state)
+	}
+	got, err = b.ContainerInspect(ctx, job.String(), false, "1.45")
+	if err != nil {
+		t.Fatalf("ContainerInspect(job) = %v", err)
+	}
+	if state := got.(types.ContainerJSON).State; state.Paused || state.Status != "exited" {
+		t.Errorf("ContainerInspect(job) state = %+v, want exited", state)
+	}
+
+	list, err := b.Containers(ctx, &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	for _, c := range list {
+		if c.ID == web.ID() && (c.State != "paused" || !strings.HasSuffix(c.Status, "(Paused)")) {
+			t.Errorf("Containers() web = %s %q, want paused", c.State, c.Status)
+		}
+	}
+
+	if err := b.ContainerPause(web.String()); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerPause(paused) = %v, want conflict", err)
+	}
+	if err := b.ContainerPause(job.String()); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerPause(exited) = %v, want conflict", err)
+	}
+	if err := b.ContainerUnpause(job.String()); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerUnpause(exited) = %v, want conflict", err)
+	}
+	if _, err := b.ContainerExecCreate(web.String(), &types.ExecConfig{Cmd: []string{"ls"}}); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerExecCreate(paused) = %v, want conflict", err)
+	}
+	if err := b.ContainerAttach(web.String(), &backend.ContainerAttachConfig{}); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerAttach(paused) = %v, want conflict", err)
+	}
+}
+
+func TestPausedStatus(t *testing.T) {
+	now := time.Now()
+	status := &corev1.ContainerStatus{State: corev1.ContainerState{
+		Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(now.Add(-time.Hour))},
+	}}
+	state := &types.ContainerState{Status: "paused", Running: true, Paused: true}
+	if got, want := humanStatus(status, state, now), "Up About an hour (Paused)"; got != want {
+		t.Errorf("humanStatus() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// trackedLabel marks the pods the informer watches: the ones levias
+	// created containers in, or that something waited on. Watching every
+	// pod in the cluster would cost far more memory than the server has.
+	trackedLabel = "levias.io/tracked"
+)
+
+// podWatcher is a shared pod informer that container state waits subscribe
+// to, so concurrent waits don't each poll or watch the API server.
+type podWatcher struct {
+	client   kubernetes.Interface
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   listersv1.PodLister
+
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newPodWatcher(client kubernetes.Interface) *podWatcher {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = trackedLabel + "=true"
+	}))
+	pods := factory.Core().V1().Pods()
+	w := &podWatcher{
+		client:   client,
+		factory:  factory,
+		informer: pods.Informer(),
+		lister:   pods.Lister(),
+		subs:     map[string]map[chan struct{}]struct{}{},
+	}
+	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.notify,
+		UpdateFunc: func(_, obj interface{}) { w.notify(obj) },
+		DeleteFunc: w.notify,
+	})
+	return w
+}
+
+// Start runs the informer and waits for its cache to sync.
+func (w *podWatcher) Start(ctx context.Context) error {
+	w.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), w.informer.HasSynced) {
+		return fmt.Errorf("failed to sync pod informer")
+	}
+	return nil
+}
+
+// Get returns the latest known version of a pod, or nil if it doesn't exist.
+func (w *podWatcher) Get(namespace, name string) (*corev1.Pod, error) {
+	pod, err := w.lister.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return pod, err
+}
+
+// Track makes sure the informer watches the pod, labelling it if needed.
+func (w *podWatcher) Track(ctx context.Context, namespace, name string) error {
+	if pod, err := w.Get(namespace, name); err == nil && pod != nil {
+		return nil
+	}
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, trackedLabel)
+	_, err := w.client.CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// WaitFor calls check with the latest version of the pod (nil if it has been
+// deleted) every time it changes, until check returns true or an error, or the
+// context is done. The pod must not be modified. Pods the informer doesn't
+// watch yet are tracked first.
+func (w *podWatcher) WaitFor(ctx context.Context, namespace, name string, check func(*corev1.Pod) (bool, error)) (*corev1.Pod, error) {
+	ch := w.subscribe(namespace, name)
+	defer w.unsubscribe(namespace, name, ch)
+
+	// syncing is set while the informer catches up with a pod that was
+	// only just tracked.
+	tracked, syncing := false, false
+	for {
+		pod, err := w.Get(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if pod == nil && !tracked {
+			tracked = true
+			err := w.Track(ctx, namespace, name)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			syncing = err == nil
+		}
+		if pod != nil {
+			syncing = false
+		}
+		if !syncing {
+			if done, err := check(pod); err != nil || done {
+				return pod, err
+			}
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (w *podWatcher) subscribe(namespace, name string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := namespace + "/" + name
+	if w.subs[key] == nil {
+		w.subs[key] = map[chan struct{}]struct{}{}
+	}
+	// Buffered so a change that happens between checks is never missed.
+	ch := make(chan struct{}, 1)
+	w.subs[key][ch] = struct{}{}
+	return ch
+}
+
+func (w *podWatcher) unsubscribe(namespace, name string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := namespace + "/" + name
+	delete(w.subs[key], ch)
+	if len(w.subs[key]) == 0 {
+		delete(w.subs, key)
+	}
+}
+
+func (w *podWatcher) notify(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// podLocks serializes changes to individual pods.
+type podLocks struct {
+	mu    sync.Mutex
+	locks map[string]*podLock
+}
+
+type podLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newPodLocks() *podLocks {
+	return &podLocks{
+		locks: map[string]*podLock{},
+	}
+}
+
+// Lock locks the given pod, returning a function to unlock it.
+func (l *podLocks) Lock(namespace, name string) func() {
+	key := namespace + "/" + name
+
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &podLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// updateEphemeralContainers applies mutate to the latest version of the pod and
+// writes the result with the ephemeralcontainers subresource. Updates to the
+// same pod are serialized, and retried if the pod changed underneath us.
+func (b *Backend) updateEphemeralContainers(ctx context.Context, namespace, name string, mutate func(*corev1.Pod) error) (*corev1.Pod, error) {
+	unlock := b.podLocks.Lock(namespace, name)
+	defer unlock()
+
+	var out *corev1.Pod
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := b.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := mutate(pod); err != nil {
+			return err
+		}
+		out, err = b.client.CoreV1().Pods(namespace).UpdateEphemeralContainers(ctx, name, pod, metav1.UpdateOptions{})
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPodWatcherTracking(t *testing.T) {
+	untracked := testPod("ns", "untracked")
+	untracked.Labels = nil
+	client := fake.NewSimpleClientset(testPod("ns", "tracked"), untracked)
+	w := newPodWatcher(client)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if pod, err := w.Get("ns", "tracked"); err != nil || pod == nil {
+		t.Errorf("Get(tracked) = %v, %v, want the pod", pod, err)
+	}
+	if pod, err := w.Get("ns", "untracked"); err != nil || pod != nil {
+		t.Errorf("Get(untracked) = %v, %v, want nothing", pod, err)
+	}
+
+	// Waiting on a pod tracks it.
+	pod, err := w.WaitFor(ctx, "ns", "untracked", func(p *corev1.Pod) (bool, error) {
+		return p != nil, nil
+	})
+	if err != nil || pod == nil {
+		t.Fatalf("WaitFor(untracked) = %v, %v, want the pod", pod, err)
+	}
+	got, err := client.CoreV1().Pods("ns").Get(ctx, "untracked", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Labels[trackedLabel] != "true" {
+		t.Errorf("labels = %v, want %s=true", got.Labels, trackedLabel)
+	}
+
+	// Pods that don't exist are reported as deleted.
+	if _, err := w.WaitFor(ctx, "ns", "missing", func(p *corev1.Pod) (bool, error) {
+		return p == nil, nil
+	}); err != nil {
+		t.Errorf("WaitFor(missing) = %v", err)
+	}
+}
+
+func TestUpdateEphemeralContainersConflict(t *testing.T) {
+	client := fake.NewSimpleClientset(testPod("ns", "pod"))
+	// The first update loses a race with another writer, which changed the
+	// pod in the meantime.
+	conflicted := false
+	client.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "ephemeralcontainers" || conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		gvr := corev1.SchemeGroupVersion.WithResource("pods")
+		obj, err := client.Tracker().Get(gvr, "ns", "pod")
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*corev1.Pod)
+		pod.Annotations = map[string]string{"other": "writer"}
+		if err := client.Tracker().Update(gvr, pod, "ns"); err != nil {
+			return true, nil, err
+		}
+		return true, nil, apierrors.NewConflict(corev1.Resource("pods"), "pod", errors.New("the object has been modified"))
+	})
+	b := &Backend{client: client, podLocks: newPodLocks()}
+
+	var seen []string
+	out, err := b.updateEphemeralContainers(context.Background(), "ns", "pod", func(pod *corev1.Pod) error {
+		seen = append(seen, pod.Annotations["other"])
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, testPod("ns", "pod", "web").Spec.EphemeralContainers...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateEphemeralContainers() = %v", err)
+	}
+	// The retry starts over from the pod the other writer left.
+	if want := []string{"", "writer"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("mutate saw annotations %q, want %q", seen, want)
+	}
+	if _, ok := findContainer(out, "web"); !ok || out.Annotations["other"] != "writer" {
+		t.Errorf("updated pod = %+v, want web added and the other write kept", out)
+	}
+}
+
+func TestPodLocks(t *testing.T) {
+	l := newPodLocks()
+	unlock := l.Lock("ns", "a")
+
+	// Other pods aren't held up by a locked one.
+	done := make(chan struct{})
+	go func() {
+		l.Lock("ns", "b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock(b) blocked on a")
+	}
+
+	// The same pod is.
+	locked := make(chan struct{})
+	go func() {
+		l.Lock("ns", "a")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Lock(a) didn't wait for a to be unlocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock(a) blocked after a was unlocked")
+	}
+
+	if len(l.locks) != 0 {
+		t.Errorf("unlocked pods left %d locks behind", len(l.locks))
+	}
+}
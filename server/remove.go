@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/errdefs"
+)
+
+// acceptedPruneFilters are the docker container prune filters we support,
+// which are the ones the docker daemon supports.
+var acceptedPruneFilters = map[string]bool{
+	"label":  true,
+	"label!": true,
+	"until":  true,
+}
+
+// removeContainers marks the named containers as removed. Kubernetes never
+// deletes ephemeral containers from a pod, so removed containers are only
+// hidden from docker. Their docker names can be reused through the pod's
+// name table.
+func (b *Backend) removeContainers(ctx context.Context, namespace, pod string, names []string) error {
+	return b.updateMetadata(ctx, namespace, pod, func(m map[string]*containerMeta) error {
+		for _, name := range names {
+			meta := m[name]
+			if meta == nil {
+				meta = &containerMeta{}
+				m[name] = meta
+			}
+			meta.Removed = true
+		}
+		return nil
+	})
+}
+
+// pruneUntil returns the time given by the until prune filter, if any.
+func pruneUntil(pruneFilters filters.Args) (time.Time, error) {
+	values := pruneFilters.Get("until")
+	if len(values) == 0 {
+		return time.Time{}, nil
+	}
+	if len(values) > 1 {
+		return time.Time{}, errdefs.InvalidParameter(errors.New("more than one until filter specified"))
+	}
+	ts, err := timetypes.GetTimestamp(values[0], time.Now())
+	if err != nil {
+		return time.Time{}, errdefs.InvalidParameter(err)
+	}
+	seconds, nanoseconds, err := timetypes.ParseTimestamps(ts, 0)
+	if err != nil {
+		return time.Time{}, errdefs.InvalidParameter(err)
+	}
+	return time.Unix(seconds, nanoseconds), nil
+}
+
+// matchPruneLabels reports whether labels match the label and label! prune
+// filters.
+func matchPruneLabels(pruneFilters filters.Args, labels map[string]string) bool {
+	if !pruneFilters.MatchKVList("label", labels) {
+		return false
+	}
+	// MatchKVList matches when there's no filter, so label! needs checking
+	// for separately.
+	return !pruneFilters.Contains("label!") || !pruneFilters.MatchKVList("label!", labels)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	containerpkg "github.com/docker/docker/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// removeTestPod returns a pod with a running container (web), and two exited
+// ones (job, labeled keep=true, and test).
+func removeTestPod() *corev1.Pod {
+	pod := testPod("ns", "pod", "web", "job", "test")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z"},` +
+			`"job":{"created":"2024-01-02T00:00:00Z","labels":{"keep":"true"}},` +
+			`"test":{"created":"2024-01-03T00:00:00Z"}}`,
+	}
+	exited := corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 0},
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}, {
+		Name:  "job",
+		State: exited,
+	}, {
+		Name:  "test",
+		State: exited,
+	}}
+	return pod
+}
+
+func TestContainerRm(t *testing.T) {
+	b := newTestBackend(t, removeTestPod())
+	ctx := callerContext("ns", "pod")
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	job := containerRef{Namespace: "ns", Pod: "pod", Name: "job"}
+
+	if err := b.ContainerRm(web.String(), &backend.ContainerRmConfig{}); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerRm(running) = %v, want conflict", err)
+	}
+	if err := b.ContainerRm(job.String(), &backend.ContainerRmConfig{}); err != nil {
+		t.Fatalf("ContainerRm(job) = %v", err)
+	}
+
+	list, err := b.Containers(ctx, &container.ListOptions{All: true})
+	if err != nil {
+		t.Fatalf("Containers() = %v", err)
+	}
+	for _, c := range list {
+		if c.ID == job.ID() {
+			t.Error("Containers() includes a removed container")
+		}
+	}
+	if len(list) != 2 {
+		t.Errorf("Containers() returned %d containers, want 2", len(list))
+	}
+	if _, err := b.ContainerInspect(ctx, job.String(), false, "1.45"); !errdefs.IsNotFound(err) {
+		t.Errorf("ContainerInspect(removed) = %v, want not found", err)
+	}
+	pod, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := matchContainer(pod, "job"); ok {
+		t.Error("matchContainer() matched a removed container")
+	}
+
+	// The name can be reused, by a container Kubernetes knows by another
+	// name.
+	created, err := b.ContainerCreate(ctx, backend.ContainerCreateConfig{
+		Name:       "job",
+		Config:     &container.Config{Image: "cgr.dev/chainguard/bash"},
+		HostConfig: &container.HostConfig{},
+	})
+	if err != nil {
+		t.Fatalf("ContainerCreate(job) = %v", err)
+	}
+	if created.ID == job.ID() {
+		t.Error("ContainerCreate(job) reused the removed container's ID")
+	}
+	if pod, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok, _ := matchContainer(pod, "job"); !ok || ref.ID() != created.ID {
+		t.Errorf("matchContainer(job) = %v, %t, want %s", ref, ok, created.ID)
+	}
+}
+
+func TestContainersPrune(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		filters filters.Args
+		want    []string
+		wantErr bool
+	}{
+		{desc: "all", want: []string{"job", "test"}},
+		{desc: "label", filters: filters.NewArgs(filters.Arg("label", "keep=true")), want: []string{"job"}},
+		{desc: "not label", filters: filters.NewArgs(filters.Arg("label!", "keep")), want: []string{"test"}},
+		{desc: "until", filters: filters.NewArgs(filters.Arg("until", "2024-01-02T12:00:00Z")), want: []string{"job"}},
+		{desc: "bad until", filters: filters.NewArgs(filters.Arg("until", "tomorrow")), wantErr: true},
+		{desc: "unknown", filters: filters.NewArgs(filters.Arg("status", "exited")), wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			b := newTestBackend(t, removeTestPod())
+			ctx := callerContext("ns", "pod")
+
+			report, err := b.ContainersPrune(ctx, tc.filters)
+			if tc.wantErr {
+				if !errdefs.IsInvalidParameter(err) {
+					t.Errorf("ContainersPrune() = %v, want invalid parameter", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ContainersPrune() = %v", err)
+			}
+
+			want := map[string]bool{}
+			for _, name := range tc.want {
+				want[containerRef{Namespace: "ns", Pod: "pod", Name: name}.ID()] = true
+			}
+			if len(report.ContainersDeleted) != len(want) {
+				t.Errorf("ContainersPrune() deleted %v, want %v", report.ContainersDeleted, tc.want)
+			}
+			for _, id := range report.ContainersDeleted {
+				if !want[id] {
+					t.Errorf("ContainersPrune() deleted %s, want %v", id, tc.want)
+				}
+			}
+
+			pod, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(podContainers(pod)); n != 3-len(tc.want) {
+				t.Errorf("%d containers left after prune, want %d", n, 3-len(tc.want))
+			}
+		})
+	}
+}
+
+func TestAutoRemove(t *testing.T) {
+	pod := removeTestPod()
+	pod.Annotations[metadataAnnotation] = `{"test":{"created":"2024-01-03T00:00:00Z","autoRemove":true}}`
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	test := containerRef{Namespace: "ns", Pod: "pod", Name: "test"}
+
+	waitC, err := b.ContainerWait(ctx, test.String(), containerpkg.WaitConditionRemoved)
+	if err != nil {
+		t.Fatalf("ContainerWait() = %v", err)
+	}
+	newSupervisor(context.Background(), b)
+
+	select {
+	case status := <-waitC:
+		if status.Err() != nil || status.ExitCode() != 0 {
+			t.Errorf("ContainerWait() = %d, %v, want 0", status.ExitCode(), status.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ContainerWait(removed) didn't return")
+	}
+
+	got, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findContainer(got, "test"); ok {
+		t.Error("container created with --rm wasn't removed")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// terminals tracks the terminal size queues of active attach and exec sessions,
+// keyed by container or exec ID. Each session has its own queue, so several
+// attaches to the same container all follow its resizes.
+type terminals struct {
+	mu       sync.Mutex
+	sessions map[string]map[*sizeQueue]bool
+}
+
+func newTerminals() *terminals {
+	return &terminals{
+		sessions: map[string]map[*sizeQueue]bool{},
+	}
+}
+
+// open returns the size queue of a new session, starting out at the size the
+// client asked for up front, if any ({height, width}, like docker's
+// ConsoleSize).
+func (t *terminals) open(id string, size *[2]uint) *sizeQueue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := &sizeQueue{
+		ch:   make(chan remotecommand.TerminalSize, 1),
+		done: make(chan struct{}),
+	}
+	if size != nil && size[0] > 0 && size[1] > 0 {
+		q.push(remotecommand.TerminalSize{Height: uint16(size[0]), Width: uint16(size[1])})
+	}
+	if t.sessions[id] == nil {
+		t.sessions[id] = map[*sizeQueue]bool{}
+	}
+	t.sessions[id][q] = true
+	return q
+}
+
+// close stops the session's size queue and forgets about it.
+func (t *terminals) close(id string, q *sizeQueue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.sessions[id][q] {
+		return
+	}
+	close(q.done)
+	delete(t.sessions[id], q)
+	if len(t.sessions[id]) == 0 {
+		delete(t.sessions, id)
+	}
+}
+
+// resize passes the new size on to the ID's open sessions. Resizes for IDs
+// without one are dropped.
+func (t *terminals) resize(id string, height, width int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for q := range t.sessions[id] {
+		q.push(remotecommand.TerminalSize{
+			Height: uint16(height),
+			Width:  uint16(width),
+		})
+	}
+}
+
+// sizeQueue implements remotecommand.TerminalSizeQueue. Only the most recent
+// size is kept since intermediate sizes are irrelevant once a newer one arrives.
+type sizeQueue struct {
+	ch   chan remotecommand.TerminalSize
+	done chan struct{}
+}
+
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.ch:
+		return &size
+	case <-q.done:
+		return nil
+	}
+}
+
+func (q *sizeQueue) push(size remotecommand.TerminalSize) {
+	for {
+		select {
+		case q.ch <- size:
+			return
+		default:
+		}
+		// Drop the stale size and try again.
+		select {
+		case <-q.ch:
+		default:
+		}
+	}
+}
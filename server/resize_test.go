@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestTerminals(t *testing.T) {
+	terms := newTerminals()
+
+	// Resizes without a session are dropped.
+	terms.resize("c1", 10, 20)
+	if len(terms.sessions) != 0 {
+		t.Errorf("resize() without a session kept %d sessions", len(terms.sessions))
+	}
+
+	a := terms.open("c1", nil)
+	b := terms.open("c1", nil)
+	terms.resize("c1", 30, 40)
+	want := remotecommand.TerminalSize{Height: 30, Width: 40}
+	for _, q := range []*sizeQueue{a, b} {
+		if got := q.Next(); got == nil || *got != want {
+			t.Errorf("Next() = %v, want %v", got, want)
+		}
+	}
+
+	// Only the most recent size is kept.
+	terms.resize("c1", 1, 1)
+	terms.resize("c1", 50, 60)
+	want = remotecommand.TerminalSize{Height: 50, Width: 60}
+	if got := a.Next(); got == nil || *got != want {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+
+	terms.close("c1", a)
+	if got := a.Next(); got != nil {
+		t.Errorf("Next() after close = %v, want nil", got)
+	}
+	terms.resize("c1", 70, 80)
+	want = remotecommand.TerminalSize{Height: 70, Width: 80}
+	if got := b.Next(); got == nil || *got != want {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+
+	// Closing twice is harmless, and the last session takes the ID with it.
+	terms.close("c1", a)
+	terms.close("c1", b)
+	if len(terms.sessions) != 0 {
+		t.Errorf("close() left %d sessions", len(terms.sessions))
+	}
+
+	// Sessions start out at the size asked for up front, until a resize
+	// replaces it.
+	c := terms.open("c2", &[2]uint{24, 80})
+	defer terms.close("c2", c)
+	want = remotecommand.TerminalSize{Height: 24, Width: 80}
+	if got := c.Next(); got == nil || *got != want {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+	terms.resize("c2", 1, 1)
+	terms.resize("c2", 30, 100)
+	want = remotecommand.TerminalSize{Height: 30, Width: 100}
+	if got := c.Next(); got == nil || *got != want {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// startContainer starts the container again if it has exited, and waits for
+// it to be running. Ephemeral containers start as soon as they're created, so
+// containers that haven't started yet are only waited for, which reports
+// image pull and config errors from docker start (and docker run -d) itself.
+func (b *Backend) startContainer(ctx context.Context, ref containerRef) error {
+	pod, c, err := b.getContainer(ctx, ref.Namespace, ref.Pod, ref.Name)
+	if err != nil {
+		return err
+	}
+	switch status := containerStatus(pod, c.Instance); {
+	case status != nil && status.State.Running != nil:
+		return nil
+	case status == nil || status.State.Terminated == nil:
+		_, err := b.waitForReady(ctx, ref.Namespace, ref.Pod, c.Instance)
+		return err
+	}
+	if !c.Ephemeral {
+		return errUnmanaged(ref.Name, ref.Pod)
+	}
+
+	// Like docker, starting a container resets its restart policy.
+	instance, err := b.recreate(ctx, ref, c.Instance, func(meta *containerMeta) error {
+		meta.Stopped = false
+		meta.RestartCount = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.waitForReady(ctx, ref.Namespace, ref.Pod, instance)
+	return err
+}
+
+// errInstanceLimit is returned when a container can't be recreated because it
+// already has as many instances as the backend allows.
+var errInstanceLimit = errors.New("instance limit reached")
+
+// recreate replaces the exited instance from of a container with a new
+// ephemeral container running the same spec, returning the new instance's
+// name. Ephemeral containers can't be restarted or removed, so the new
+// instance gets a name of its own and the container's docker name and ID move
+// over to it. update is applied to the container's metadata.
+func (b *Backend) recreate(ctx context.Context, ref containerRef, from string, update func(*containerMeta) error) (string, error) {
+	instance := instanceName(ref.Name)
+
+	// The instance is recorded first, so it's never seen as a container of
+	// its own. Until it shows up in the spec, the previous instance is used.
+	if err := b.updateMetadata(ctx, ref.Namespace, ref.Pod, func(m map[string]*containerMeta) error {
+		meta := m[ref.Name]
+		if meta == nil {
+			meta = &containerMeta{}
+			m[ref.Name] = meta
+		}
+		if err := update(meta); err != nil {
+			return err
+		}
+		// Kubernetes never removes ephemeral containers from a pod, so
+		// every instance stays in it for good.
+		if n := 1 + len(meta.Instances); b.maxInstances > 0 && n >= b.maxInstances {
+			return errdefs.Forbidden(fmt.Errorf("container %s can't be started again, it already has %d instances in pod %s: %w", ref.Name, n, ref.Pod, errInstanceLimit))
+		}
+		// The new instance starts out running, even if the old one was
+		// paused when it exited.
+		meta.Paused = false
+		meta.Instances = append(meta.Instances, instance)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	_, err := b.updateEphemeralContainers(ctx, ref.Namespace, ref.Pod, func(pod *corev1.Pod) error {
+		if nameInUse(pod, instance) {
+			return errdefs.Conflict(fmt.Errorf("container name %s is already in use", instance))
+		}
+		c, ok := findContainer(pod, ref.Name)
+		if !ok || !c.Ephemeral {
+			return errdefs.NotFound(fmt.Errorf("container %s not found", ref.Name))
+		}
+		if c.Instance != from {
+			return errdefs.Conflict(fmt.Errorf("container %s was already restarted", ref.Name))
+		}
+		ec := findEphemeralContainer(pod, c.Instance).DeepCopy()
+		ec.Name = instance
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, *ec)
+		return nil
+	})
+	if err != nil {
+		// Forget the instance again, it will never exist.
+		if err := b.updateMetadata(ctx, ref.Namespace, ref.Pod, func(m map[string]*containerMeta) error {
+			if meta := m[ref.Name]; meta != nil {
+				meta.Instances = removeString(meta.Instances, instance)
+			}
+			return nil
+		}); err != nil {
+			fmt.Printf("error cleaning up metadata for %s: %v\n", ref.Name, err)
+		}
+		return "", err
+	}
+	return instance, nil
+}
+
+// instanceName generates the name of a new instance of the named container.
+func instanceName(name string) string {
+	suffix := "-" + rand.String(5)
+	if max := validation.DNS1123LabelMaxLength - len(suffix); len(name) > max {
+		name = name[:max]
+	}
+	return name + suffix
+}
+
+func removeString(s []string, v string) []string {
+	var out []string
+	for _, e := range s {
+		if e != v {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// validateRestartPolicy checks a restart policy the way the docker daemon
+// does on create.
+func validateRestartPolicy(hc *container.HostConfig) error {
+	if hc == nil {
+		return nil
+	}
+	if err := container.ValidateRestartPolicy(hc.RestartPolicy); err != nil {
+		return err
+	}
+	if hc.AutoRemove && !hc.RestartPolicy.IsNone() {
+		return errdefs.InvalidParameter(fmt.Errorf("can't create 'AutoRemove' container with restart policy"))
+	}
+	return nil
+}
+
+// shouldRestart reports whether the container's restart policy restarts it
+// given the status of its current instance.
+func shouldRestart(meta *containerMeta, status *corev1.ContainerStatus) bool {
+	policy := meta.RestartPolicy
+	if policy == nil || meta.Stopped || status == nil || status.State.Terminated == nil {
+		return false
+	}
+	switch {
+	case policy.IsAlways(), policy.IsUnlessStopped():
+		return true
+	case policy.IsOnFailure():
+		if max := policy.MaximumRetryCount; max == 0 || meta.RestartCount < max {
+			return status.State.Terminated.ExitCode != 0
+		}
+	}
+	return false
+}
+
+// killStops reports whether docker kill with sig keeps the container's restart
+// policy from restarting it. Following the docker daemon, that's the case for
+// signals that stop the container, and any signal if the container only
+// restarts unless stopped.
+func killStops(meta *containerMeta, sig syscall.Signal) bool {
+	if meta.RestartPolicy != nil && meta.RestartPolicy.IsUnlessStopped() {
+		return true
+	}
+	return isStopSignal(meta, sig)
+}
+
+// isStopSignal reports whether docker treats sig as stopping the container:
+// SIGKILL, the container's stop signal, or any signal if it has none.
+func isStopSignal(meta *containerMeta, sig syscall.Signal) bool {
+	if sig == syscall.SIGKILL || meta.StopSignal == "" {
+		return true
+	}
+	stopSig, err := parseSignal(meta.StopSignal, syscall.SIGTERM)
+	return err == nil && sig == stopSig
+}
+
+// markStopped records that the container was stopped through docker, so its
+// restart policy leaves it alone until it's started again.
+func (b *Backend) markStopped(ctx context.Context, ref containerRef, meta *containerMeta) error {
+	if meta.RestartPolicy == nil || meta.Stopped {
+		return nil
+	}
+	return b.updateMetadata(ctx, ref.Namespace, ref.Pod, func(m map[string]*containerMeta) error {
+		if meta := m[ref.Name]; meta != nil {
+			meta.Stopped = true
+		}
+		return nil
+	})
+}
+
+// markRestarting reports the container as restarting while its restart policy
+// brings it back: from when it exits until its new instance is running.
+func markRestarting(state *types.ContainerState, status *corev1.ContainerStatus, meta *containerMeta) {
+	starting := meta.RestartCount > 0 && (status == nil || status.State.Waiting != nil)
+	if starting || shouldRestart(meta, status) {
+		state.Status = "restarting"
+		state.Restarting = true
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecreate(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "db")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","labels":{"app":"web"}}}`,
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	ctx := context.Background()
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+
+	noop := func(*containerMeta) error { return nil }
+	instances := []string{"web"}
+	for i := 0; i < 2; i++ {
+		instance, err := b.recreate(ctx, web, instances[i], noop)
+		if err != nil {
+			t.Fatalf("recreate(web) = %v", err)
+		}
+		if !strings.HasPrefix(instance, "web-") {
+			t.Errorf("recreate(web) = %s, want web-*", instance)
+		}
+		instances = append(instances, instance)
+	}
+
+	got, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(got.Spec.EphemeralContainers); n != 4 {
+		t.Fatalf("pod has %d ephemeral containers, want 4", n)
+	}
+	var names []string
+	for _, c := range podContainers(got) {
+		names = append(names, c.Name)
+	}
+	// The container moves to the position of its newest instance.
+	if strings.Join(names, ",") != "db,web" {
+		t.Errorf("podContainers() = %v, want [db web]", names)
+	}
+
+	c, ok := findContainer(got, "web")
+	if !ok {
+		t.Fatal("findContainer(web) not found")
+	}
+	if c.Instance != instances[2] {
+		t.Errorf("web instance = %s, want %s", c.Instance, instances[2])
+	}
+	if c.Image != "cgr.dev/chainguard/bash" {
+		t.Errorf("web image = %s, want the original spec", c.Image)
+	}
+	if c.fieldPath() != "spec.ephemeralContainers{"+instances[2]+"}" {
+		t.Errorf("web fieldPath() = %s", c.fieldPath())
+	}
+	if labels := containerLabels(got, c); labels["app"] != "web" {
+		t.Errorf("web labels = %v, want app=web", labels)
+	}
+
+	for _, name := range []string{"web", web.ID(), web.ID()[:12]} {
+		ref, ok, err := matchContainer(got, name)
+		if err != nil || !ok || ref != web {
+			t.Errorf("matchContainer(%s) = %v, %t, %v, want %v", name, ref, ok, err, web)
+		}
+	}
+	for _, name := range instances[1:] {
+		if _, ok, _ := matchContainer(got, name); ok {
+			t.Errorf("matchContainer(%s) found a replaced instance", name)
+		}
+	}
+
+	// Restarting from an instance that has already been replaced fails, so
+	// concurrent restarts don't both go through.
+	if _, err := b.recreate(ctx, web, instances[1], noop); !errdefs.IsConflict(err) {
+		t.Errorf("recreate(web) from a replaced instance = %v, want conflict", err)
+	}
+	got, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta := containerMetadata(got, "web"); len(meta.Instances) != 2 {
+		t.Errorf("web instances = %v, want the failed one to be forgotten", meta.Instances)
+	}
+}
+
+func TestPendingInstance(t *testing.T) {
+	// The instance is recorded before it's added to the spec, in the meantime
+	// the previous one is still current.
+	pod := testPod("ns", "pod", "web")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","instances":["web-abcde"]}}`,
+	}
+	c, ok := findContainer(pod, "web")
+	if !ok || c.Instance != "web" {
+		t.Errorf("findContainer(web) = %+v, %t, want instance web", c, ok)
+	}
+}
+
+func TestStartRunningContainer(t *testing.T) {
+	pod := testPod("ns", "pod", "web")
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+
+	if err := b.ContainerStart(ctx, web.String(), "", ""); err != nil {
+		t.Fatalf("ContainerStart(web) = %v", err)
+	}
+	got, err := b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(got.Spec.EphemeralContainers); n != 1 {
+		t.Errorf("pod has %d ephemeral containers after starting a running container, want 1", n)
+	}
+}
+
+// TestStartPendingContainer covers docker run -d, which creates a container
+// and starts it without attaching: start waits for the container, so pull and
+// config errors are reported by start itself.
+func TestStartPendingContainer(t *testing.T) {
+	for _, tc := range []struct {
+		reason  string
+		wantErr func(error) bool
+	}{
+		{reason: "ErrImagePull", wantErr: errdefs.IsNotFound},
+		{reason: "ImagePullBackOff", wantErr: errdefs.IsNotFound},
+		{reason: "CreateContainerConfigError", wantErr: errdefs.IsInvalidParameter},
+	} {
+		pod := testPod("ns", "pod", "web")
+		pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+			Name: "web",
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: tc.reason, Message: "typo/image not found"},
+			},
+		}}
+		b := newTestBackend(t, pod)
+		b.startTimeout = 5 * time.Second
+		ctx := callerContext("ns", "pod")
+		web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+
+		if err := b.ContainerStart(ctx, web.String(), "", ""); !tc.wantErr(err) {
+			t.Errorf("%s: ContainerStart() = %v, want it reported as a start error", tc.reason, err)
+		}
+	}
+
+	// Containers without a status yet are waited for until they run.
+	pod := testPod("ns", "pod", "web")
+	b := newTestBackend(t, pod)
+	b.startTimeout = 5 * time.Second
+	ctx := callerContext("ns", "pod")
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+
+	started := make(chan error, 1)
+	go func() { started <- b.ContainerStart(ctx, web.String(), "", "") }()
+	select {
+	case err := <-started:
+		t.Fatalf("ContainerStart() = %v before the container started", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()},
+		},
+	}}
+	if _, err := b.client.CoreV1().Pods("ns").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("ContainerStart() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("ContainerStart() didn't return once the container started")
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	name := instanceName(strings.Repeat("a", 63))
+	if len(name) > 63 || !strings.HasPrefix(name, "aaaa") {
+		t.Errorf("instanceName() = %s, want a valid container name", name)
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	exited := func(code int32) *corev1.ContainerStatus {
+		return &corev1.ContainerStatus{State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: code},
+		}}
+	}
+	running := &corev1.ContainerStatus{State: corev1.ContainerState{
+		Running: &corev1.ContainerStateRunning{},
+	}}
+	policy := func(name container.RestartPolicyMode, max int) *container.RestartPolicy {
+		return &container.RestartPolicy{Name: name, MaximumRetryCount: max}
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		meta   containerMeta
+		status *corev1.ContainerStatus
+		want   bool
+	}{
+		{desc: "no policy", meta: containerMeta{}, status: exited(1)},
+		{desc: "always", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyAlways, 0)}, status: exited(0), want: true},
+		{desc: "running", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyAlways, 0)}, status: running},
+		{desc: "not started", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyAlways, 0)}},
+		{desc: "stopped", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyAlways, 0), Stopped: true}, status: exited(137)},
+		{desc: "unless-stopped", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyUnlessStopped, 0)}, status: exited(0), want: true},
+		{desc: "on-failure success", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyOnFailure, 0)}, status: exited(0)},
+		{desc: "on-failure failure", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyOnFailure, 0)}, status: exited(1), want: true},
+		{desc: "on-failure retries left", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyOnFailure, 3), RestartCount: 2}, status: exited(1), want: true},
+		{desc: "on-failure retries exhausted", meta: containerMeta{RestartPolicy: policy(container.RestartPolicyOnFailure, 3), RestartCount: 3}, status: exited(1)},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := shouldRestart(&tc.meta, tc.status); got != tc.want {
+				t.Errorf("shouldRestart() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKillStops(t *testing.T) {
+	always := &container.RestartPolicy{Name: container.RestartPolicyAlways}
+	unlessStopped := &container.RestartPolicy{Name: container.RestartPolicyUnlessStopped}
+	for _, tc := range []struct {
+		meta containerMeta
+		sig  syscall.Signal
+		want bool
+	}{
+		{meta: containerMeta{RestartPolicy: always}, sig: syscall.SIGKILL, want: true},
+		{meta: containerMeta{RestartPolicy: always}, sig: syscall.SIGHUP, want: true},
+		{meta: containerMeta{RestartPolicy: always, StopSignal: "SIGINT"}, sig: syscall.SIGINT, want: true},
+		{meta: containerMeta{RestartPolicy: always, StopSignal: "SIGINT"}, sig: syscall.SIGHUP},
+		{meta: containerMeta{RestartPolicy: unlessStopped, StopSignal: "SIGINT"}, sig: syscall.SIGHUP, want: true},
+	} {
+		if got := killStops(&tc.meta, tc.sig); got != tc.want {
+			t.Errorf("killStops(%+v, %d) = %t, want %t", tc.meta, tc.sig, got, tc.want)
+		}
+	}
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		hc      container.HostConfig
+		wantErr bool
+	}{
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways}}},
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3}}},
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways, MaximumRetryCount: 3}}, wantErr: true},
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: "sometimes"}}, wantErr: true},
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways}, AutoRemove: true}, wantErr: true},
+		{hc: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled}, AutoRemove: true}},
+	} {
+		err := validateRestartPolicy(&tc.hc)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("validateRestartPolicy(%+v) = %v, want error %t", tc.hc.RestartPolicy, err, tc.wantErr)
+		}
+		if err != nil && !errdefs.IsInvalidParameter(err) {
+			t.Errorf("validateRestartPolicy(%+v) = %v, want invalid parameter", tc.hc.RestartPolicy, err)
+		}
+	}
+
+	// Restart policies are honored, so they don't warn.
+	config := cliConfig()
+	config.HostConfig.AutoRemove = false
+	config.HostConfig.RestartPolicy = container.RestartPolicy{Name: container.RestartPolicyAlways}
+	if warnings, err := checkCompat(config, true); err != nil || len(warnings) > 0 {
+		t.Errorf("checkCompat(--restart) = %v, %v, want no warnings", warnings, err)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "job")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","restartPolicy":{"Name":"on-failure","MaximumRetryCount":2}},` +
+			`"job":{"created":"2024-01-01T00:00:00Z","restartPolicy":{"Name":"on-failure"}}}`,
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+		},
+	}, {
+		Name: "job",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 0},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	s := newSupervisor(context.Background(), b)
+
+	ctx := context.Background()
+	var got *corev1.Pod
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		got, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Spec.EphemeralContainers) > 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(got.Spec.EphemeralContainers); n != 3 {
+		t.Fatalf("pod has %d ephemeral containers, want web to be restarted once", n)
+	}
+	c, _ := findContainer(got, "web")
+	if c.Instance == "web" {
+		t.Error("web wasn't restarted")
+	}
+
+	// While the new instance starts, the container is restarting.
+	inspect := containerJSON(got, c)
+	if !inspect.State.Restarting || inspect.RestartCount != 1 {
+		t.Errorf("inspect state = %+v, restart count %d, want restarting after 1 restart", inspect.State, inspect.RestartCount)
+	}
+	if p := inspect.HostConfig.RestartPolicy; p.Name != container.RestartPolicyOnFailure || p.MaximumRetryCount != 2 {
+		t.Errorf("inspect restart policy = %+v, want on-failure:2", p)
+	}
+
+	// The same exit is only restarted from once.
+	if s.claim(containerRef{Namespace: "ns", Pod: "pod", Name: "web"}, "web") {
+		t.Error("claim() claimed the same instance twice")
+	}
+}
+
+func TestInstanceLimit(t *testing.T) {
+	pod := testPod("ns", "pod", "web", "web-abcde")
+	pod.Annotations = map[string]string{
+		metadataAnnotation: `{"web":{"created":"2024-01-01T00:00:00Z","restartPolicy":{"Name":"always"},"instances":["web-abcde"]}}`,
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name: "web-abcde",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+		},
+	}}
+	b := newTestBackend(t, pod)
+	b.maxInstances = 2
+	newSupervisor(context.Background(), b)
+
+	// The restart policy gives up instead of adding a third instance.
+	ctx := callerContext("ns", "pod")
+	var got *corev1.Pod
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		got, err = b.client.CoreV1().Pods("ns").Get(ctx, "pod", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if containerMetadata(got, "web").Stopped || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !containerMetadata(got, "web").Stopped {
+		t.Fatal("web wasn't stopped")
+	}
+	if n := len(got.Spec.EphemeralContainers); n != 2 {
+		t.Errorf("pod has %d ephemeral containers, want 2", n)
+	}
+	var stopped bool
+	for _, e := range loggedEvents(b.events) {
+		stopped = stopped || e == "container stop"
+	}
+	if !stopped {
+		t.Error("no stop event was logged")
+	}
+
+	// Neither can docker start it again.
+	web := containerRef{Namespace: "ns", Pod: "pod", Name: "web"}
+	if err := b.ContainerStart(ctx, web.String(), "", ""); !errdefs.IsForbidden(err) {
+		t.Errorf("ContainerStart() = %v, want forbidden", err)
+	}
+}
+
+func TestRestartingStatus(t *testing.T) {
+	now := time.Now()
+	state := &types.ContainerState{Status: "restarting", Restarting: true}
+	status := &corev1.ContainerStatus{State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, FinishedAt: metav1.NewTime(now.Add(-time.Minute))},
+	}}
+	if got, want := humanStatus(status, state, now), "Restarting (1) About a minute ago"; got != want {
+		t.Errorf("humanStatus() = %q, want %q", got, want)
+	}
+	if got, want := humanStatus(nil, state, now), "Restarting"; got != want {
+		t.Errorf("humanStatus() = %q, want %q", got, want)
+	}
+}
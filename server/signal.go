@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/moby/sys/signal"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// defaultStopTimeout is how long docker stop waits before escalating to
+	// SIGKILL, matching the docker daemon.
+	defaultStopTimeout = 10
+	// killTimeout is how long to wait for a container to exit after SIGKILL.
+	killTimeout = 10 * time.Second
+)
+
+// findMainScript finds the container's main process and stores its PID in
+// $main. Kubernetes has no API for signalling containers, so signals are sent
+// by scripts that run as an exec inside the container and only rely on shell
+// builtins.
+//
+// The main process is the oldest one in the container's mount namespace.
+// That's PID 1 unless the pod shares its process namespace, in which case PID
+// 1 is the pod's pause container and must be left alone.
+const findMainScript = `
+main= oldest=
+for d in /proc/[0-9]*; do
+	[ "$d/ns/mnt" -ef /proc/self/ns/mnt ] || continue
+	[ "${d#/proc/}" = "$$" ] && continue
+	read -r stat 2>/dev/null < "$d/stat" || continue
+	set -- ${stat##*") "}
+	if [ -z "$main" ] || [ "${20}" -lt "$oldest" ]; then
+		main=${d#/proc/} oldest=${20}
+	fi
+done
+[ -n "$main" ] || { echo "no process found" >&2; exit 1; }
+`
+
+// signalScript sends signal $1 to the container's main process.
+//
+// PID 1 ignores SIGKILL sent from inside its own PID namespace, so SIGKILL
+// also goes to every other process in the namespace. With a shell as PID 1
+// that's usually enough for it to exit.
+const signalScript = `sig=$1` + findMainScript + `
+kill -"$sig" "$main" || exit 1
+if [ "$sig" = 9 ] && [ "$main" = 1 ]; then
+	kill -9 -1 2>/dev/null
+fi
+exit 0
+`
+
+// parseSignal parses a docker signal (name with or without SIG, or number),
+// using def if none is given.
+func parseSignal(s string, def syscall.Signal) (syscall.Signal, error) {
+	if s == "" {
+		return def, nil
+	}
+	sig, err := signal.ParseSignal(s)
+	if err != nil {
+		return 0, errdefs.InvalidParameter(err)
+	}
+	return sig, nil
+}
+
+// signalContainer delivers sig to the main process of the container.
+func (b *Backend) signalContainer(ctx context.Context, namespace, pod, container string, sig syscall.Signal) error {
+	var stderr bytes.Buffer
+	err := b.exec(ctx, namespace, pod, container, []string{"sh", "-c", signalScript, "sh", strconv.Itoa(int(sig))}, remotecommand.StreamOptions{
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return errdefs.System(fmt.Errorf("failed to signal container %s: %v: %s", container, err, bytes.TrimSpace(stderr.Bytes())))
+	}
+	return nil
+}
+
+// waitForExit waits up to timeout for the container to terminate. A negative
+// timeout waits forever.
+func (b *Backend) waitForExit(ctx context.Context, namespace, pod, container string, timeout time.Duration) (*corev1.ContainerStatus, error) {
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	status, err := b.waitForContainer(ctx, namespace, pod, container, func(s *corev1.ContainerStatus) bool {
+		return s.State.Terminated != nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, errdefs.Deadline(fmt.Errorf("container %s did not exit within %s", container, timeout))
+	}
+	return status, err
+}
+
+// kill sends SIGKILL and waits for the container to exit.
+func (b *Backend) kill(ctx context.Context, namespace, pod, container string) error {
+	if err := b.signalContainer(ctx, namespace, pod, container, syscall.SIGKILL); err != nil {
+		return err
+	}
+	if _, err := b.waitForExit(ctx, namespace, pod, container, killTimeout); err != nil {
+		if errdefs.IsDeadline(err) {
+			return errdefs.System(fmt.Errorf("container %s did not exit after SIGKILL: its main process runs as PID 1, which can't be killed from inside the container. Use shareProcessNamespace on the pod to allow it", container))
+		}
+		return err
+	}
+	return nil
+}
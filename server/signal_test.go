@@ -0,0 +1,64 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want syscall.Signal
+	}{
+		{in: "", want: syscall.SIGTERM},
+		{in: "SIGINT", want: syscall.SIGINT},
+		{in: "HUP", want: syscall.SIGHUP},
+		{in: "9", want: syscall.SIGKILL},
+	} {
+		got, err := parseSignal(tc.in, syscall.SIGTERM)
+		if err != nil {
+			t.Fatalf("parseSignal(%q) = %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Errorf("parseSignal(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	if _, err := parseSignal("SIGNOPE", syscall.SIGTERM); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("parseSignal(SIGNOPE) = %v, want invalid parameter error", err)
+	}
+}
+
+func TestKillStopPreconditions(t *testing.T) {
+	pod := testPod("ns", "pod", "done")
+	pod.Spec.Containers = []corev1.Container{{Name: "main"}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "main", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{
+		{Name: "done", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+	}
+	b := newTestBackend(t, pod)
+	ctx := callerContext("ns", "pod")
+	main := containerRef{Namespace: "ns", Pod: "pod", Name: "main"}.String()
+	done := containerRef{Namespace: "ns", Pod: "pod", Name: "done"}.String()
+
+	if err := b.ContainerKill(main, ""); !errdefs.IsForbidden(err) {
+		t.Errorf("ContainerKill(main) = %v, want forbidden error", err)
+	}
+	if err := b.ContainerStop(ctx, main, container.StopOptions{}); !errdefs.IsForbidden(err) {
+		t.Errorf("ContainerStop(main) = %v, want forbidden error", err)
+	}
+	if err := b.ContainerKill(done, ""); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerKill(done) = %v, want conflict error", err)
+	}
+	if err := b.ContainerKill(done, "SIGNOPE"); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("ContainerKill(done, SIGNOPE) = %v, want invalid parameter error", err)
+	}
+	if err := b.ContainerStop(ctx, done, container.StopOptions{}); err != nil {
+		t.Errorf("ContainerStop(done) = %v, want nil for an exited container", err)
+	}
+}
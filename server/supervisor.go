@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Restart policy backoff, matching the docker daemon: the delay doubles
+	// with every restart, and resets once a container runs for a while.
+	minRestartDelay   = 100 * time.Millisecond
+	maxRestartDelay   = time.Minute
+	restartResetAfter = 10 * time.Second
+)
+
+// errRestartCanceled is returned when a container is stopped through docker
+// while its restart policy is about to restart it.
+var errRestartCanceled = errors.New("restart canceled")
+
+// supervisor carries out what happens to containers after they exit: restart
+// policies and --rm. It watches the same informer as everything else, so it also catches up on
+// containers that exited while the server was down.
+type supervisor struct {
+	ctx context.Context
+	b   *Backend
+
+	mu sync.Mutex
+	// pending holds the instances being acted on, keyed like container refs.
+	pending map[string]bool
+	// delays holds the last backoff delay of each container.
+	delays map[string]time.Duration
+}
+
+func newSupervisor(ctx context.Context, b *Backend) *supervisor {
+	s := &supervisor{
+		ctx:     ctx,
+		b:       b,
+		pending: map[string]bool{},
+		delays:  map[string]time.Duration{},
+	}
+	b.pods.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.check,
+		UpdateFunc: func(_, obj interface{}) { s.check(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				s.forget(pod)
+			}
+		},
+	})
+	return s
+}
+
+// check acts on the pod's containers: it restarts exited containers
+// according to their restart policy, removes exited containers created with
+// --rm, and kills removed containers that started anyway.
+func (s *supervisor) check(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	for _, c := range allPodContainers(pod) {
+		if !c.Ephemeral {
+			continue
+		}
+		ref := containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: c.Name}
+		instance := c.Instance
+		meta := containerMetadata(pod, c.Name)
+		status := containerStatus(pod, instance)
+
+		switch {
+		case c.Removed:
+			// Containers removed before they started still start, since
+			// there's no way to take them out of the pod.
+			if status != nil && status.State.Running != nil && s.claim(ref, instance) {
+				go s.reap(ref, instance)
+			}
+		case meta.AutoRemove:
+			if status != nil && status.State.Terminated != nil && s.claim(ref, instance) {
+				go s.remove(pod, c)
+			}
+		case shouldRestart(meta, status):
+			if s.claim(ref, instance) {
+				time.AfterFunc(s.backoff(ref, status.State.Terminated), func() { s.restart(ref, instance) })
+			}
+		}
+	}
+}
+
+// claim records that the instance is being acted on, returning false if it
+// already is.
+func (s *supervisor) claim(ref containerRef, instance string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := containerRef{Namespace: ref.Namespace, Pod: ref.Pod, Name: instance}.String()
+	if s.pending[key] {
+		return false
+	}
+	s.pending[key] = true
+	return true
+}
+
+// release lets the instance be acted on again on the pod's next update.
+func (s *supervisor) release(ref containerRef, instance string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, containerRef{Namespace: ref.Namespace, Pod: ref.Pod, Name: instance}.String())
+}
+
+// backoff returns how long to wait before restarting a container that exited
+// as described by t.
+func (s *supervisor) backoff(ref containerRef, t *corev1.ContainerStateTerminated) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delay := s.delays[ref.String()] * 2
+	if delay == 0 || t.FinishedAt.Sub(t.StartedAt.Time) >= restartResetAfter {
+		delay = minRestartDelay
+	}
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+	s.delays[ref.String()] = delay
+	return delay
+}
+
+func (s *supervisor) restart(ref containerRef, instance string) {
+	_, err := s.b.recreate(s.ctx, ref, instance, func(meta *containerMeta) error {
+		// The container may have been stopped, started or removed through
+		// docker in the meantime.
+		if meta.Stopped || meta.Removed {
+			return errRestartCanceled
+		}
+		meta.RestartCount++
+		return nil
+	})
+	if err == nil || errors.Is(err, errRestartCanceled) || errdefs.IsConflict(err) {
+		return
+	}
+	if errors.Is(err, errInstanceLimit) {
+		s.giveUp(ref, instance, err)
+		return
+	}
+	fmt.Printf("error restarting container %s: %v\n", ref, err)
+	s.release(ref, instance)
+}
+
+// giveUp stops restarting a container that can't be recreated anymore, the
+// way docker stops once a restart policy's maximum retry count is reached.
+func (s *supervisor) giveUp(ref containerRef, instance string, reason error) {
+	fmt.Printf("not restarting container %s: %v\n", ref, reason)
+	if err := s.b.updateMetadata(s.ctx, ref.Namespace, ref.Pod, func(m map[string]*containerMeta) error {
+		if meta := m[ref.Name]; meta != nil {
+			meta.Stopped = true
+		}
+		return nil
+	}); err != nil {
+		fmt.Printf("error stopping container %s: %v\n", ref, err)
+		s.release(ref, instance)
+		return
+	}
+	s.b.events.logContainerRef(ref, events.ActionStop, map[string]string{"reason": reason.Error()})
+}
+
+func (s *supervisor) remove(pod *corev1.Pod, c podContainer) {
+	if err := s.b.removeContainers(s.ctx, pod.Namespace, pod.Name, []string{c.Name}); err != nil {
+		fmt.Printf("error removing container %s/%s/%s: %v\n", pod.Namespace, pod.Name, c.Name, err)
+		s.release(containerRef{Namespace: pod.Namespace, Pod: pod.Name, Name: c.Name}, c.Instance)
+		return
+	}
+	s.b.events.logContainer(pod, c, events.ActionDestroy, nil)
+}
+
+func (s *supervisor) reap(ref containerRef, instance string) {
+	if err := s.b.signalContainer(s.ctx, ref.Namespace, ref.Pod, instance, syscall.SIGKILL); err != nil {
+		fmt.Printf("error killing removed container %s: %v\n", ref, err)
+		s.release(ref, instance)
+	}
+}
+
+// forget drops the state kept for a deleted pod's containers.
+func (s *supervisor) forget(pod *corev1.Pod) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := podRef(pod) + "/"
+	for key := range s.pending {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.pending, key)
+		}
+	}
+	for key := range s.delays {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.delays, key)
+		}
+	}
+}
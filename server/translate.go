@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// translateConfig converts a docker container config into the equivalent
+// ephemeral container in pod.
+func translateConfig(pod *corev1.Pod, name string, config backend.ContainerCreateConfig) (corev1.EphemeralContainer, error) {
+	cfg := config.Config
+	ec := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:       name,
+			Image:      cfg.Image,
+			Command:    cfg.Entrypoint,
+			Args:       cfg.Cmd,
+			WorkingDir: cfg.WorkingDir,
+			Env:        translateEnv(cfg.Env),
+
+			Stdin:     cfg.OpenStdin,
+			StdinOnce: cfg.StdinOnce,
+			TTY:       cfg.Tty,
+		},
+	}
+
+	if cfg.User != "" {
+		uid, gid, err := parseUser(cfg.User)
+		if err != nil {
+			return corev1.EphemeralContainer{}, err
+		}
+		ec.SecurityContext = &corev1.SecurityContext{
+			RunAsUser:  uid,
+			RunAsGroup: gid,
+		}
+	}
+
+	mounts, err := translateMounts(pod, config.HostConfig)
+	if err != nil {
+		return corev1.EphemeralContainer{}, err
+	}
+	ec.VolumeMounts = mounts
+
+	return ec, nil
+}
+
+// translateEnv converts KEY=value pairs into env vars. Bare keys are dropped -
+// the docker CLI has already resolved them from the caller's environment.
+func translateEnv(env []string) []corev1.EnvVar {
+	var out []corev1.EnvVar
+	for _, e := range env {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok || k == "" {
+			continue
+		}
+		out = append(out, corev1.EnvVar{
+			Name:  k,
+			Value: v,
+		})
+	}
+	return out
+}
+
+// parseUser parses a docker user spec (uid or uid:gid). Only numeric IDs are
+// supported since there's no way to look up names in the image beforehand.
+func parseUser(user string) (*int64, *int64, error) {
+	u, g, hasGroup := strings.Cut(user, ":")
+	uid, err := strconv.ParseInt(u, 10, 64)
+	if err != nil {
+		return nil, nil, errdefs.InvalidParameter(fmt.Errorf("invalid user %q: only numeric uid[:gid] is supported", user))
+	}
+	if !hasGroup {
+		return &uid, nil, nil
+	}
+	gid, err := strconv.ParseInt(g, 10, 64)
+	if err != nil {
+		return nil, nil, errdefs.InvalidParameter(fmt.Errorf("invalid user %q: only numeric uid[:gid] is supported", user))
+	}
+	return &uid, &gid, nil
+}
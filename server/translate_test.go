@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTranslateConfig(t *testing.T) {
+	uid, gid := int64(1000), int64(2000)
+	got, err := translateConfig(&corev1.Pod{}, "db", backend.ContainerCreateConfig{
+		Config: &container.Config{
+			Image:      "cgr.dev/chainguard/bash",
+			Entrypoint: []string{"bash", "-c"},
+			Cmd:        []string{"env"},
+			Env:        []string{"FOO=bar", "EMPTY=", "A=b=c", "UNSET"},
+			WorkingDir: "/work",
+			User:       "1000:2000",
+			OpenStdin:  true,
+			Tty:        true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("translateConfig() = %v", err)
+	}
+	want := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:       "db",
+			Image:      "cgr.dev/chainguard/bash",
+			Command:    []string{"bash", "-c"},
+			Args:       []string{"env"},
+			WorkingDir: "/work",
+			Env: []corev1.EnvVar{
+				{Name: "FOO", Value: "bar"},
+				{Name: "EMPTY"},
+				{Name: "A", Value: "b=c"},
+			},
+			SecurityContext: &corev1.SecurityContext{
+				RunAsUser:  &uid,
+				RunAsGroup: &gid,
+			},
+			Stdin: true,
+			TTY:   true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("translateConfig() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseUser(t *testing.T) {
+	for _, tc := range []struct {
+		user     string
+		uid, gid int64
+		hasGroup bool
+		wantErr  bool
+	}{
+		{user: "0", uid: 0},
+		{user: "1000", uid: 1000},
+		{user: "1000:1000", uid: 1000, gid: 1000, hasGroup: true},
+		{user: "nobody", wantErr: true},
+		{user: "1000:staff", wantErr: true},
+	} {
+		uid, gid, err := parseUser(tc.user)
+		if tc.wantErr {
+			if !errdefs.IsInvalidParameter(err) {
+				t.Errorf("parseUser(%s) = %v, want invalid parameter error", tc.user, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parseUser(%s) = %v", tc.user, err)
+		}
+		if *uid != tc.uid {
+			t.Errorf("parseUser(%s) uid = %d, want %d", tc.user, *uid, tc.uid)
+		}
+		if (gid != nil) != tc.hasGroup || (gid != nil && *gid != tc.gid) {
+			t.Errorf("parseUser(%s) gid = %v, want %d", tc.user, gid, tc.gid)
+		}
+	}
+}